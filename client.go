@@ -162,6 +162,7 @@ func (c *client) do(method string, resource string, payload map[string]string, a
 		} else if apiErr == nil {
 			return response, ErrMalformedErrorResponse
 		}
+		apiErr.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
 
 		return response, apiErr
 	}
@@ -0,0 +1,88 @@
+// Command hitbtc-watch prints the ticker and the top of the order book of a
+// hitbtc market until interrupted.
+//
+//	hitbtc-watch -symbol ETHBTC
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bitzlato/go-hitbtc"
+)
+
+func main() {
+	symbol := flag.String("symbol", "ETHBTC", "market to watch")
+	url := flag.String("url", "", "websocket endpoint, the hitbtc api by default")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := watch(ctx, *symbol, *url); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// watch prints the notifications of the market until ctx is done or the
+// connection is lost.
+func watch(ctx context.Context, symbol, url string) error {
+	var opts []hitbtc.Option
+	if url != "" {
+		opts = append(opts, hitbtc.WithURL(url))
+	}
+	client, err := hitbtc.NewWSClient(opts...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ticker, err := client.Subscribe(hitbtc.FeedTicker, symbol)
+	if err != nil {
+		return err
+	}
+	defer ticker.Unsubscribe()
+
+	orderbook, err := client.Subscribe(hitbtc.FeedOrderbook, symbol, hitbtc.WithMaintainedOrderBook(), hitbtc.WithCoalescing())
+	if err != nil {
+		return err
+	}
+	defer orderbook.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-client.Done():
+			return errors.New("connection lost")
+		case t := <-ticker.Ticker():
+			fmt.Printf("%s ticker bid=%s ask=%s last=%s volume=%s\n", t.Timestamp, t.Bid, t.Ask, t.Last, t.Volume)
+		case s := <-orderbook.OrderbookSnapshots():
+			fmt.Printf("orderbook snapshot sequence=%d bids=%d asks=%d\n", s.Sequence, len(s.Bid), len(s.Ask))
+		case u := <-orderbook.OrderbookUpdates():
+			printTop(u.Sequence, orderbook.OrderBook())
+		case err := <-ticker.Err():
+			log.Printf("ticker: %v", err)
+		case err := <-orderbook.Err():
+			log.Printf("orderbook: %v", err)
+		}
+	}
+}
+
+func printTop(sequence int64, book *hitbtc.OrderBook) {
+	bid, _ := book.BestBid()
+	ask, _ := book.BestAsk()
+	spread, err := book.Spread()
+	if err != nil {
+		fmt.Printf("orderbook sequence=%d: %v\n", sequence, err)
+		return
+	}
+	fmt.Printf("orderbook sequence=%d bid=%s@%s ask=%s@%s spread=%s\n",
+		sequence, bid.Size, bid.Price, ask.Size, ask.Price, spread)
+}
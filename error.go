@@ -4,16 +4,97 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
 )
 
 var _ json.Unmarshaler = &APIError{}
 
 var ErrMalformedErrorResponse = errors.New("malformed error response")
 
+// ErrClientClosed is returned when operating on a closed WSClient.
+var ErrClientClosed = errors.New("websocket client is closed")
+
+// ErrConnectionLost is the last error of a WSClient whose connection was lost.
+var ErrConnectionLost = errors.New("websocket connection lost")
+
+// ErrIteratorDone is returned by an iterator once it is exhausted.
+var ErrIteratorDone = errors.New("no more items in iterator")
+
+// ErrOrderBookNotReady is returned when updating an order book before applying a snapshot.
+var ErrOrderBookNotReady = errors.New("order book snapshot not applied")
+
+// ErrEmptyOrderBook is returned when a value needs an order book side that is empty.
+var ErrEmptyOrderBook = errors.New("order book side is empty")
+
+// ErrSubscriptionConflict is returned when subscribing again to a feed with
+// other options than the existing subscription, e.g. another candles period.
+var ErrSubscriptionConflict = errors.New("feed already subscribed with other options")
+
+// ErrChannelsNotRegistered is returned when subscribing to a feed whose
+// channels were not registered beforehand, see WithExplicitChannels.
+var ErrChannelsNotRegistered = errors.New("feed channels not registered")
+
+// ErrCircuitOpen is returned by PlaceOrder while the circuit is open after
+// consecutive failures, see WithOrderCircuitBreaker.
+var ErrCircuitOpen = errors.New("order placement circuit is open")
+
+// ErrOrderOutcomeUnknown is returned by PlaceOrder when an order already sent
+// with the same client order id is neither active nor known to be completed:
+// it may have been filled at once, e.g. a market or IOC order, or never placed.
+// The order is not sent again, its outcome must be checked in the order
+// history before placing it with a new client order id.
+var ErrOrderOutcomeUnknown = errors.New("order outcome unknown")
+
+// ErrResponseTimeout is returned when a call received no response before its
+// deadline, e.g. when hitbtc answered it with an unknown id, as opposed to an
+// error reported by hitbtc. The error matches context.DeadlineExceeded as well.
+var ErrResponseTimeout = errors.New("no response before the deadline")
+
+// SymbolError is an error of the notifications of a feed, e.g. a notification
+// that could not be decoded. The symbol is empty for the reports feed and when
+// it could not be decoded.
+type SymbolError struct {
+	Symbol   string
+	FeedKind FeedKind
+	Err      error
+}
+
+func (e *SymbolError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.FeedKind, e.Symbol, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *SymbolError) Unwrap() error {
+	return e.Err
+}
+
+// UnsubscribeAllError holds the failed unsubscriptions of UnsubscribeAll, as
+// *SymbolError.
+type UnsubscribeAllError struct {
+	Errs []error
+}
+
+func (e *UnsubscribeAllError) Error() string {
+	messages := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d unsubscriptions failed: %s", len(e.Errs), strings.Join(messages, "; "))
+}
+
 type APIError struct {
-	Code        int    `json:"code"`
-	Message     string `json:"message,omitempty"`
-	Description string `json:"description,omitempty"`
+	Code        int             `json:"code"`
+	Message     string          `json:"message,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+
+	retryAfter time.Duration // from the Retry-After header of the response
 }
 
 func (e *APIError) UnmarshalJSON(data []byte) error {
@@ -26,9 +107,68 @@ func (e *APIError) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, aux); err != nil {
 		return err
 	}
+	e.fillValidation()
 	return nil
 }
 
+// CodeOrderDeadlineExceeded is the code of the APIError returned when the
+// internal execution deadline of an order is exceeded, the order not being
+// placed, see APIError.IsDeadlineExceeded.
+const CodeOrderDeadlineExceeded = 20080
+
+// CodeActionForbidden is the code of the APIError returned when the API key
+// is not allowed to perform the action.
+const CodeActionForbidden = 1003
+
+// CodeValidationError is the code of the APIError returned when the params of
+// a request are not valid, the reason being in the message or the description.
+const CodeValidationError = 10001
+
+// IsValidationError reports whether err is an *APIError of code
+// CodeValidationError, whose Message and Description carry the reason.
+func IsValidationError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == CodeValidationError
+}
+
+// fillValidation ensures that a validation error carries its reason in both
+// its Message and its Description, hitbtc putting it in either of them or in
+// the data of the error depending on the api.
+func (e *APIError) fillValidation() {
+	if e.Code != CodeValidationError {
+		return
+	}
+	if e.Description == "" && len(e.Data) > 0 && string(e.Data) != "null" {
+		if err := json.Unmarshal(e.Data, &e.Description); err != nil {
+			e.Description = string(e.Data)
+		}
+	}
+	if e.Message == "" {
+		e.Message = e.Description
+	}
+	if e.Message == "" {
+		e.Message = "Validation error"
+	}
+	if e.Description == "" {
+		e.Description = e.Message
+	}
+}
+
+// wsAPIError converts the error of a websocket call answered by hitbtc into an
+// *APIError, leaving the other errors as is.
+func wsAPIError(err error) error {
+	var rpcErr *jsonrpc2.Error
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+	apiErr := &APIError{Code: int(rpcErr.Code), Message: rpcErr.Message}
+	if rpcErr.Data != nil {
+		apiErr.Data = *rpcErr.Data
+	}
+	apiErr.fillValidation()
+	return apiErr
+}
+
 func IsAPIError(v interface{}) bool {
 	_, ok := v.(*APIError)
 	return ok
@@ -38,6 +178,100 @@ func (e *APIError) Error() string {
 	return fmt.Sprintf("HitBTC <APIError> code=%d, message=%q, description=%q", e.Code, e.Message, e.Description)
 }
 
+// retryAfterPattern matches the retry timings found in the error descriptions,
+// e.g. "Try it again in 30 seconds" or "retry after 500ms".
+var retryAfterPattern = regexp.MustCompile(`(?i)(?:retry[- ]after|again in|retry in)\s*:?\s*(\d+(?:\.\d+)?)\s*(ms|milliseconds?|s|secs?|seconds?|m|mins?|minutes?)?\b`)
+
+// RetryAfter returns the delay after which the request may be retried, as
+// advised by hitbtc when it is unavailable (code 503) or rate limiting
+// (code 429). The delay is taken from the Retry-After header of the response,
+// or parsed from the description or the data of the error.
+func (e *APIError) RetryAfter() (time.Duration, bool) {
+	if e.retryAfter > 0 {
+		return e.retryAfter, true
+	}
+	for _, text := range []string{e.Description, string(e.Data), e.Message} {
+		m := retryAfterPattern.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			continue
+		}
+		unit := time.Second
+		switch suffix := strings.ToLower(m[2]); {
+		case suffix == "ms" || strings.HasPrefix(suffix, "milli"):
+			unit = time.Millisecond
+		case strings.HasPrefix(suffix, "m"):
+			unit = time.Minute
+		}
+		return time.Duration(value * float64(unit)), true
+	}
+	return 0, false
+}
+
+// IsRateLimited reports whether the action is rate limited for the account
+// (code 429).
+func (e *APIError) IsRateLimited() bool {
+	return e.Code == 429
+}
+
+// IsDeadlineExceeded reports whether the internal execution deadline of the
+// order was exceeded, the order not being placed or replaced (code 20080).
+func (e *APIError) IsDeadlineExceeded() bool {
+	return e.Code == CodeOrderDeadlineExceeded
+}
+
+// IsAuth reports whether the session is not authorized for the action: the
+// authorization is required or failed, the API key is not allowed the action,
+// or the method is unsupported (codes 1001 to 1004).
+func (e *APIError) IsAuth() bool {
+	return e.Code >= 1001 && e.Code <= 1004
+}
+
+// IsInsufficientFunds reports whether the funds are insufficient for the order
+// or the account operation (code 20001).
+func (e *APIError) IsInsufficientFunds() bool {
+	return e.Code == 20001
+}
+
+// IsRetryable reports whether hitbtc failed on its side, the request may then
+// be retried (codes 500, 503 and 504). After a gateway timeout (code 504), the
+// result of the request must be checked first: it may have been performed.
+func (e *APIError) IsRetryable() bool {
+	switch e.Code {
+	case 500, 503, 504:
+		return true
+	}
+	return false
+}
+
+// IsNotFound reports whether the symbol, the currency, the order, the
+// transaction or the payout of the request does not exist (codes 2001, 2002,
+// 20002, 20004 and 20005).
+func (e *APIError) IsNotFound() bool {
+	switch e.Code {
+	case 2001, 2002, 20002, 20004, 20005:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses the Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
 /*
    Error code	HTTP Status Code	Message	                                    Note
    403	        401	                Action is forbidden for account
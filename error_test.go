@@ -0,0 +1,95 @@
+package hitbtc
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIErrorRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		body  string
+		delay time.Duration
+		ok    bool
+	}{
+		{`{"error":{"code":503,"message":"Service Unavailable","description":"Try it again in 30 seconds"}}`, 30 * time.Second, true},
+		{`{"error":{"code":503,"message":"Service Unavailable","data":{"hint":"retry after 1.5 min"}}}`, 90 * time.Second, true},
+		{`{"error":{"code":429,"message":"Too many requests","description":"Retry-After: 500ms"}}`, 500 * time.Millisecond, true},
+		{`{"error":{"code":503,"message":"Service Unavailable","description":"Try it again later"}}`, 0, false},
+	} {
+		var apiErr APIError
+		require.NoError(t, json.Unmarshal([]byte(tc.body), &apiErr))
+		delay, ok := apiErr.RetryAfter()
+		require.Equal(t, tc.ok, ok, tc.body)
+		require.Equal(t, tc.delay, delay, tc.body)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, 2*time.Second, parseRetryAfter("2", now))
+	require.Equal(t, time.Minute, parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now))
+}
+
+func TestValidationError(t *testing.T) {
+	for _, tc := range []struct {
+		body        string
+		message     string
+		description string
+	}{
+		{`{"error":{"code":10001,"message":"Validation error","description":"quantity must be positive"}}`, "Validation error", "quantity must be positive"},
+		{`{"error":{"code":10001,"message":"Validation error"}}`, "Validation error", "Validation error"},
+		{`{"error":{"code":10001,"data":"price is required"}}`, "price is required", "price is required"},
+		{`{"error":{"code":10001}}`, "Validation error", "Validation error"},
+	} {
+		apiErr := new(APIError)
+		require.NoError(t, json.Unmarshal([]byte(tc.body), apiErr))
+		require.Equal(t, tc.message, apiErr.Message, tc.body)
+		require.Equal(t, tc.description, apiErr.Description, tc.body)
+		require.True(t, IsValidationError(errors.Annotate(apiErr, "PlaceOrder")), tc.body)
+	}
+
+	data := json.RawMessage(`"side is invalid"`)
+	err := wsAPIError(&jsonrpc2.Error{Code: CodeValidationError, Message: "Validation error", Data: &data})
+	require.True(t, IsValidationError(err))
+	require.Equal(t, "side is invalid", err.(*APIError).Description)
+
+	require.False(t, IsValidationError(&APIError{Code: 20001, Message: "Insufficient funds"}))
+	require.False(t, IsValidationError(errors.New("Validation error")))
+}
+
+func TestAPIErrorClassification(t *testing.T) {
+	for _, tc := range []struct {
+		code                                                       int
+		rateLimited, auth, insufficient, retry, notFound, deadline bool
+	}{
+		{429, true, false, false, false, false, false},
+		{403, false, false, false, false, false, false},
+		{1001, false, true, false, false, false, false},
+		{1004, false, true, false, false, false, false},
+		{1005, false, false, false, false, false, false},
+		{20001, false, false, true, false, false, false},
+		{500, false, false, false, true, false, false},
+		{503, false, false, false, true, false, false},
+		{504, false, false, false, true, false, false},
+		{2001, false, false, false, false, true, false},
+		{2002, false, false, false, false, true, false},
+		{20002, false, false, false, false, true, false},
+		{20003, false, false, false, false, false, false},
+		{20004, false, false, false, false, true, false},
+		{20005, false, false, false, false, true, false},
+		{CodeValidationError, false, false, false, false, false, false},
+		{CodeOrderDeadlineExceeded, false, false, false, false, false, true},
+	} {
+		apiErr := &APIError{Code: tc.code}
+		require.Equal(t, tc.rateLimited, apiErr.IsRateLimited(), tc.code)
+		require.Equal(t, tc.auth, apiErr.IsAuth(), tc.code)
+		require.Equal(t, tc.insufficient, apiErr.IsInsufficientFunds(), tc.code)
+		require.Equal(t, tc.retry, apiErr.IsRetryable(), tc.code)
+		require.Equal(t, tc.notFound, apiErr.IsNotFound(), tc.code)
+		require.Equal(t, tc.deadline, apiErr.IsDeadlineExceeded(), tc.code)
+	}
+}
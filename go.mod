@@ -5,8 +5,10 @@ go 1.18
 require (
 	github.com/gorilla/websocket v1.5.0
 	github.com/juju/errors v1.0.0
+	github.com/shopspring/decimal v1.3.1
 	github.com/sourcegraph/jsonrpc2 v0.1.0
 	github.com/stretchr/testify v1.8.1
+	go.uber.org/goleak v1.2.1
 )
 
 require (
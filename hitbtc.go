@@ -267,12 +267,50 @@ const (
 	TransferTypeExchangeToBank transferType = "exchangeToBank"
 )
 
+// AccountType is an account of the user, between which the balances are transferred.
+type AccountType string
+
+const (
+	// AccountBank is the bank (withdraw) account, receiving the deposits.
+	AccountBank AccountType = "bank"
+	// AccountExchange is the exchange (trading) account.
+	AccountExchange AccountType = "exchange"
+)
+
+// TransferTypeBetween returns the type of a transfer from an account to the
+// other, or an error if an account type is unknown or both are the same.
+func TransferTypeBetween(from, to AccountType) (transferType, error) {
+	switch {
+	case from == AccountBank && to == AccountExchange:
+		return TransferTypeBankToExchange, nil
+	case from == AccountExchange && to == AccountBank:
+		return TransferTypeExchangeToBank, nil
+	case from == to:
+		return "", fmt.Errorf("invalid transfer from %q to the same account", from)
+	}
+	return "", fmt.Errorf("invalid transfer from %q to %q: the account types are %q and %q", from, to, AccountBank, AccountExchange)
+}
+
+// Transfer transfers the amount of the currency from an account to the other,
+// see TransferBalance. The accounts are validated before sending the request.
+func (b *HitBtc) Transfer(currency string, amount float64, from, to AccountType) (transferID string, err error) {
+	transferType, err := TransferTypeBetween(from, to)
+	if err != nil {
+		return "", err
+	}
+	return b.TransferBalance(currency, amount, transferType)
+}
+
 // TransferBalance performs a balance transfer operation between trading and bank accounts (both directions).
 func (b *HitBtc) TransferBalance(currency string, amount float64, transferType transferType) (transferID string, err error) {
 	type transferResponse struct {
 		ID string `json:"id"`
 	}
 
+	if transferType != TransferTypeBankToExchange && transferType != TransferTypeExchangeToBank {
+		return "", fmt.Errorf("invalid transfer type %q", transferType)
+	}
+
 	payload := map[string]string{
 		"currency": currency,
 		"amount":   fmt.Sprint(amount),
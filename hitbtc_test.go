@@ -84,3 +84,28 @@ func TestGetOpenOrders(t *testing.T) {
 	t.Logf("GetOpenOrders : %#v\n", orders)
 	require.NoError(t, err, defaultErrorMessage)
 }
+
+func TestNewClientOrderID(t *testing.T) {
+	id := hitbtc.NewClientOrderID()
+	require.Len(t, id, 32)
+	require.Regexp(t, "^[0-9a-zA-Z]+$", id)
+	require.NotEqual(t, id, hitbtc.NewClientOrderID())
+
+	id = hitbtc.NewClientOrderIDWithPrefix("grid-1")
+	require.Len(t, id, 32)
+	require.Regexp(t, "^grid1[0-9a-f]+$", id)
+}
+
+func TestTransferTypeBetween(t *testing.T) {
+	transferType, err := hitbtc.TransferTypeBetween(hitbtc.AccountBank, hitbtc.AccountExchange)
+	require.NoError(t, err)
+	require.Equal(t, hitbtc.TransferTypeBankToExchange, transferType)
+	transferType, err = hitbtc.TransferTypeBetween(hitbtc.AccountExchange, hitbtc.AccountBank)
+	require.NoError(t, err)
+	require.Equal(t, hitbtc.TransferTypeExchangeToBank, transferType)
+
+	_, err = hitbtc.TransferTypeBetween(hitbtc.AccountBank, hitbtc.AccountBank)
+	require.Error(t, err)
+	_, err = hitbtc.TransferTypeBetween("trading", hitbtc.AccountBank)
+	require.Error(t, err)
+}
@@ -1,6 +1,8 @@
 package hitbtc
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"time"
 )
@@ -57,3 +59,33 @@ func (t *Order) UnmarshalJSON(data []byte) error {
 	}
 	return nil
 }
+
+// clientOrderIDLength is the maximum length of a client order id accepted by hitbtc.
+const clientOrderIDLength = 32
+
+// maxClientOrderIDPrefix leaves at least 64 random bits in prefixed ids.
+const maxClientOrderIDPrefix = 16
+
+// NewClientOrderID generates a unique client order id of 32 alphanumeric characters.
+func NewClientOrderID() string {
+	return NewClientOrderIDWithPrefix("")
+}
+
+// NewClientOrderIDWithPrefix generates a unique client order id starting with prefix,
+// e.g. to attribute orders to a strategy.
+// Non alphanumeric characters are removed from the prefix, which is truncated to 16 characters.
+func NewClientOrderIDWithPrefix(prefix string) string {
+	clean := make([]byte, 0, len(prefix))
+	for i := 0; i < len(prefix) && len(clean) < maxClientOrderIDPrefix; i++ {
+		ch := prefix[i]
+		if ('0' <= ch && ch <= '9') || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') {
+			clean = append(clean, ch)
+		}
+	}
+
+	random := make([]byte, (clientOrderIDLength-len(clean)+1)/2)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	return (string(clean) + hex.EncodeToString(random))[:clientOrderIDLength]
+}
@@ -3,7 +3,10 @@ package hitbtc
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/juju/errors"
@@ -14,159 +17,760 @@ import (
 const wsAPIURL string = "wss://api.hitbtc.com/api/2/ws"
 
 // responseChannels handles all incoming data from the hitbtc connection.
+//
+// The notifications are delivered from the goroutines of the connection
+// handler, so every access to the feeds is guarded by mu. A delivery never
+// holds mu while blocked on a consumer channel: it registers itself on the
+// subscription instead, which is waited for before closing the channels.
 type responseChannels struct {
-	notifications notificationChannels
+	mu     sync.RWMutex
+	feeds  map[feedKey]*Subscription
+	raw    RawHandler
+	closed bool
+
+	done     chan struct{}  // closed when the handler is closed
+	inflight sync.WaitGroup // running Handle calls
+
+	state     handlerState
+	overflows chan OverflowEvent // nil unless WithOverflowFeed
+	fills     fillWaiters        // of WaitForFill
+	orders    openOrders         // last known, recovered on reconnection
+
+	client *WSClient // owning the handler, nil when used alone
+
+	reusing int  // feeds decoding their updates into their buffers, guarded by mu
+	paused  bool // see Pause, guarded by mu
 
-	OrderbookFeed map[string]chan WSNotificationOrderbookSnapshot
-	TradesFeed    map[string]chan WSNotificationTradesSnapshot
-	CandlesFeed   map[string]chan WSNotificationCandlesSnapshot
+	fastDecoding bool      // of the order book updates, see WithFastDecoding
+	keepRaw      bool      // in the notifications, see WithRawNotifications
+	kinds        feedKinds // handled, every kind when empty, see WithFeedKinds
 
 	ErrorFeed chan error
 }
 
-// notificationChannels contains all the notifications from hitbtc for subscribed feeds.
-type notificationChannels struct {
-	TickerFeed    map[string]chan WSNotificationTickerResponse
-	OrderbookFeed map[string]chan WSNotificationOrderbookUpdate
-	TradesFeed    map[string]chan WSNotificationTradesUpdate
-	CandlesFeed   map[string]chan WSNotificationCandlesUpdate
+func newResponseChannels() *responseChannels {
+	return &responseChannels{
+		feeds:     make(map[feedKey]*Subscription),
+		done:      make(chan struct{}),
+		ErrorFeed: make(chan error),
+	}
+}
+
+// lookup returns the feed subscribed for the symbol, or nil if there is none.
+func (h *responseChannels) lookup(kind FeedKind, symbol string) *Subscription {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.feeds[feedKey{kind: kind, symbol: symbol}]
+}
+
+// acquire returns the feed subscribed for the symbol, or nil if there is none,
+// registering a delivery on it. The delivery must be released once done.
+func (h *responseChannels) acquire(kind FeedKind, symbol string) *Subscription {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := h.feeds[feedKey{kind: kind, symbol: symbol}]
+	if s != nil {
+		s.inflight.Add(1)
+	}
+	return s
+}
+
+// subscribe returns the feed for the symbol, creating it if needed.
+//
+// The options are only applied when the feed is created.
+func (h *responseChannels) subscribe(kind FeedKind, symbol string, opts ...SubOption) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := feedKey{kind: kind, symbol: symbol}
+	f, ok := h.feeds[key]
+	if !ok {
+		f = newSubscription(kind, symbol, newSubOptions(opts))
+		f.client = h.client
+		h.feeds[key] = f
+		if f.reusesBuffers() {
+			h.reusing++
+		}
+	}
+	return f
+}
+
+// add adds a feed whose channels were registered beforehand, unless the feed
+// is subscribed already. It returns the feed subscribed.
+func (h *responseChannels) add(s *Subscription) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := feedKey{kind: s.kind, symbol: s.symbol}
+	if f, ok := h.feeds[key]; ok {
+		return f
+	}
+	h.feeds[key] = s
+	if s.reusesBuffers() {
+		h.reusing++
+	}
+	return s
+}
+
+// unsubscribe closes the channels of the feed for the symbol and forgets it.
+// It reports whether the feed was subscribed.
+func (h *responseChannels) unsubscribe(kind FeedKind, symbol string) bool {
+	key := feedKey{kind: kind, symbol: symbol}
+	h.mu.Lock()
+	f, ok := h.feeds[key]
+	delete(h.feeds, key)
+	if ok && f.reusesBuffers() {
+		h.reusing--
+	}
+	h.mu.Unlock()
+
+	if ok {
+		f.close()
+	}
+	return ok
+}
+
+// subscriptions returns the current subscriptions.
+func (h *responseChannels) subscriptions() []*Subscription {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	subs := make([]*Subscription, 0, len(h.feeds))
+	for _, s := range h.feeds {
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// closeAll closes the channels of every feed once the running deliveries are
+// done. Notifications received afterwards are dropped.
+func (h *responseChannels) closeAll() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.done)
+	feeds := h.feeds
+	h.feeds = make(map[feedKey]*Subscription)
+	h.mu.Unlock()
+
+	for _, f := range feeds {
+		f.close()
+	}
+	h.inflight.Wait()
+	close(h.ErrorFeed)
+}
+
+// enter registers a Handle call, it returns false once the handler is closed.
+func (h *responseChannels) enter() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return false
+	}
+	h.inflight.Add(1)
+	return true
+}
+
+// sendError delivers an error to the ErrorFeed unless the handler is closed.
+func (h *responseChannels) sendError(err error) {
+	h.state.error()
+	select {
+	case h.ErrorFeed <- err:
+	case <-h.done:
+	}
+}
+
+// fail reports a notification of the feed that could not be decoded to the
+// ErrorFeed and to the errors of the subscription, if its symbol is known, as
+// a *SymbolError.
+func (h *responseChannels) fail(kind FeedKind, params json.RawMessage, err error) {
+	symbol := "" // the account feeds are not bound to a symbol
+	if kind != FeedReports && kind != FeedBalance {
+		var msg struct {
+			Symbol string `json:"symbol"`
+		}
+		_ = json.Unmarshal(params, &msg)
+		symbol = msg.Symbol
+	}
+	err = &SymbolError{Symbol: symbol, FeedKind: kind, Err: err}
+
+	if f := h.acquire(kind, symbol); f != nil {
+		f.report(err)
+		f.inflight.Done()
+	}
+	h.sendError(err)
+}
+
+// overflow reports a notification dropped by a non-blocking delivery. The
+// event is dropped as well when the OverflowFeed is full.
+func (h *responseChannels) overflow(kind FeedKind, symbol string, sequence int64) {
+	h.state.drop()
+	if h.overflows == nil {
+		return
+	}
+	select {
+	case h.overflows <- OverflowEvent{Symbol: symbol, FeedKind: kind, DroppedSeq: sequence}:
+	default:
+	}
+}
+
+// rawOf returns the params of a notification to keep in its decoded value, nil
+// unless WithRawNotifications is set.
+func (h *responseChannels) rawOf(message json.RawMessage) json.RawMessage {
+	if !h.keepRaw {
+		return nil
+	}
+	return message
+}
+
+// deliverReport records the execution report and delivers it to the reports
+// feed, if subscribed.
+func (h *responseChannels) deliverReport(msg WSReport) {
+	h.fills.report(msg)
+	h.orders.report(msg)
+	f := h.acquire(FeedReports, "")
+	if f == nil {
+		return
+	}
+	defer f.inflight.Done()
+	if f.opts.fillsOnly && msg.ReportType != ReportTypeTrade && !msg.Synthetic || h.held(FeedReports, "", 0) {
+		return
+	}
+	select {
+	case f.reports <- msg:
+	case <-f.done:
+	}
+}
+
+// handleRaw delivers a notification not routed to a feed to the raw handler, if any.
+func (h *responseChannels) handleRaw(method string, params json.RawMessage) {
+	if h.raw != nil {
+		h.raw(method, params)
+	}
 }
 
 // Handle handles all incoming connections and fills the channels properly.
 func (h *responseChannels) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
-	if req.Params != nil {
-		message := *req.Params
-		switch req.Method {
-		case "ticker":
-			var msg WSNotificationTickerResponse
-			err := json.Unmarshal(message, &msg)
-			if err != nil {
-				h.ErrorFeed <- err
-			} else {
-				h.notifications.TickerFeed[msg.Symbol] <- msg
+	if !h.enter() {
+		return
+	}
+	defer h.inflight.Done()
+	h.state.notification()
+
+	if req.Params == nil {
+		// every notification documented by hitbtc carries params, the param-less
+		// ones (e.g. heartbeats) are only delivered to the raw handler.
+		h.handleRaw(req.Method, nil)
+		return
+	}
+
+	message := *req.Params
+	if h.skips(req.Method) {
+		h.handleRaw(req.Method, message)
+		return
+	}
+	switch req.Method {
+	case "ticker":
+		var msg WSNotificationTickerResponse
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedTicker, message, err)
+			break
+		}
+		msg.Raw = h.rawOf(message)
+		h.state.ticker(msg)
+		if f := h.acquire(FeedTicker, msg.Symbol); f != nil {
+			if f.opts.changesOnly && !f.tickerChanged(msg) || h.held(FeedTicker, msg.Symbol, 0) {
+				f.inflight.Done()
+				break
 			}
-		case "snapshotOrderbook":
-			var msg WSNotificationOrderbookSnapshot
-			err := json.Unmarshal(message, &msg)
-			if err != nil {
-				h.ErrorFeed <- err
-			} else {
-				h.OrderbookFeed[msg.Symbol] <- msg
+			msg.Symbol = h.symbolName(msg.Symbol)
+			select {
+			case f.ticker <- msg:
+			case <-f.done:
 			}
-		case "updateOrderbook":
-			var msg WSNotificationOrderbookUpdate
-			err := json.Unmarshal(message, &msg)
-			if err != nil {
-				h.ErrorFeed <- err
-			} else {
-				h.notifications.OrderbookFeed[msg.Symbol] <- msg
+			f.inflight.Done()
+		}
+	case "snapshotOrderbook":
+		var msg WSNotificationOrderbookSnapshot
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedOrderbook, message, err)
+			break
+		}
+		msg.Raw = h.rawOf(message)
+		h.state.sequence(msg.Symbol, msg.Sequence)
+		if f := h.acquire(FeedOrderbook, msg.Symbol); f != nil {
+			if !f.freshSnapshot(msg.Sequence) {
+				f.inflight.Done()
+				break
 			}
-		case "snapshotTrades":
-			var msg WSNotificationTradesSnapshot
-			err := json.Unmarshal(message, &msg)
-			if err != nil {
-				h.ErrorFeed <- err
-			} else {
-				h.TradesFeed[msg.Symbol] <- msg
+			if f.book != nil {
+				if err := f.book.ApplySnapshot(msg); err != nil {
+					f.report(&SymbolError{Symbol: msg.Symbol, FeedKind: FeedOrderbook, Err: err})
+				}
 			}
-		case "updateTrades":
-			var msg WSNotificationTradesUpdate
-			err := json.Unmarshal(message, &msg)
-			if err != nil {
-				h.ErrorFeed <- err
-			} else {
-				h.notifications.TradesFeed[msg.Symbol] <- msg
+			if f.coalescer != nil {
+				f.coalescer.reset()
 			}
-		case "snapshotCandles":
-			var msg WSNotificationCandlesSnapshot
-			err := json.Unmarshal(message, &msg)
-			if err != nil {
-				h.ErrorFeed <- err
-			} else {
-				h.CandlesFeed[msg.Symbol] <- msg
+			if h.held(FeedOrderbook, msg.Symbol, msg.Sequence) {
+				f.inflight.Done()
+				break
 			}
-		case "updateCandles":
-			var msg WSNotificationCandlesUpdate
-			err := json.Unmarshal(message, &msg)
-			if err != nil {
-				h.ErrorFeed <- err
-			} else {
-				h.notifications.CandlesFeed[msg.Symbol] <- msg
+			msg.Symbol = h.symbolName(msg.Symbol)
+			select {
+			case f.orderbookSnapshots <- msg:
+			case <-f.done:
 			}
+			f.inflight.Done()
 		}
+	case "updateOrderbook":
+		if h.handleReusedUpdate(message) {
+			break
+		}
+		var msg WSNotificationOrderbookUpdate
+		var err error
+		if !h.fastDecoding || !decodeOrderbookUpdate(message, &msg) {
+			err = json.Unmarshal(message, &msg)
+		}
+		if err != nil {
+			h.fail(FeedOrderbook, message, err)
+			break
+		}
+		msg.Raw = h.rawOf(message)
+		h.state.sequence(msg.Symbol, msg.Sequence)
+		if f := h.acquire(FeedOrderbook, msg.Symbol); f != nil {
+			if f.book != nil {
+				if err := f.book.ApplyUpdate(msg); err != nil {
+					f.report(&SymbolError{Symbol: msg.Symbol, FeedKind: FeedOrderbook, Err: err})
+				}
+			}
+			symbol := msg.Symbol
+			msg.Symbol = h.symbolName(symbol)
+			switch {
+			case f.coalescer != nil:
+				if dropped, ok := f.coalescer.merge(msg); ok {
+					h.overflow(FeedOrderbook, symbol, dropped)
+				}
+				if !h.isPaused() {
+					f.coalescer.wake()
+				}
+			case h.held(FeedOrderbook, symbol, msg.Sequence):
+			default:
+				select {
+				case f.orderbookUpdates <- msg:
+				case <-f.done:
+				}
+			}
+			f.inflight.Done()
+		}
+	case "snapshotTrades":
+		var msg WSNotificationTradesSnapshot
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedTrades, message, err)
+		} else if f := h.acquire(FeedTrades, msg.Symbol); f != nil {
+			if h.held(FeedTrades, msg.Symbol, 0) {
+				f.inflight.Done()
+				break
+			}
+			msg.Symbol, msg.Raw = h.symbolName(msg.Symbol), h.rawOf(message)
+			select {
+			case f.tradesSnapshots <- msg:
+			case <-f.done:
+			}
+			f.inflight.Done()
+		}
+	case "updateTrades":
+		var msg WSNotificationTradesUpdate
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedTrades, message, err)
+		} else if f := h.acquire(FeedTrades, msg.Symbol); f != nil {
+			if h.held(FeedTrades, msg.Symbol, 0) {
+				f.inflight.Done()
+				break
+			}
+			msg.Symbol, msg.Raw = h.symbolName(msg.Symbol), h.rawOf(message)
+			select {
+			case f.tradesUpdates <- msg:
+			case <-f.done:
+			}
+			f.inflight.Done()
+		}
+	case "snapshotCandles":
+		var msg WSNotificationCandlesSnapshot
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedCandles, message, err)
+		} else if f := h.acquire(FeedCandles, msg.Symbol); f != nil {
+			if f.opts.closedCandles && len(msg.Data) > 0 {
+				f.closing.reset(msg.Data[len(msg.Data)-1])
+			}
+			if h.held(FeedCandles, msg.Symbol, 0) {
+				f.inflight.Done()
+				break
+			}
+			msg.Symbol, msg.Raw = h.symbolName(msg.Symbol), h.rawOf(message)
+			if f.candleBars == nil {
+				select {
+				case f.candlesSnapshots <- msg:
+				case <-f.done:
+				}
+			}
+			f.inflight.Done()
+		}
+	case "updateCandles":
+		var msg WSNotificationCandlesUpdate
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedCandles, message, err)
+		} else if f := h.acquire(FeedCandles, msg.Symbol); f != nil {
+			deliver := true
+			if f.opts.closedCandles {
+				msg.Data, deliver = f.closing.push(msg.Data)
+			}
+			if deliver && h.held(FeedCandles, msg.Symbol, 0) {
+				deliver = false
+			}
+			msg.Symbol, msg.Raw = h.symbolName(msg.Symbol), h.rawOf(message)
+			if deliver && f.candleBars != nil {
+				h.deliverBar(f, msg)
+			} else if deliver {
+				select {
+				case f.candlesUpdates <- msg:
+				case <-f.done:
+				}
+			}
+			f.inflight.Done()
+		}
+	case "activeOrders":
+		var msg []WSReport
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedReports, message, err)
+			break
+		}
+		h.orders.reset(msg)
+		if f := h.acquire(FeedReports, ""); f != nil {
+			if h.held(FeedReports, "", 0) {
+				f.inflight.Done()
+				break
+			}
+			// the snapshot sent again on reconnection is dropped when the previous one was not read.
+			select {
+			case f.activeOrders <- msg:
+			default:
+				h.overflow(FeedReports, "", 0)
+			}
+			f.inflight.Done()
+		} else {
+			// hitbtc may send the active orders after login without subscription.
+			h.handleRaw(req.Method, message)
+		}
+	case "balance":
+		var msg []WSBalance
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedBalance, message, err)
+		} else if f := h.acquire(FeedBalance, ""); f != nil {
+			if h.held(FeedBalance, "", 0) {
+				f.inflight.Done()
+				break
+			}
+			select {
+			case f.balances <- msg:
+			case <-f.done:
+			}
+			f.inflight.Done()
+		}
+	case "report":
+		var msg WSReport
+		err := json.Unmarshal(message, &msg)
+		if err != nil {
+			h.fail(FeedReports, message, err)
+			break
+		}
+		msg.Raw = h.rawOf(message)
+		h.deliverReport(msg)
+	default:
+		h.handleRaw(req.Method, message)
 	}
 }
 
 // WSClient represents a JSON RPC v2 Connection over Websocket,
 type WSClient struct {
-	conn    *jsonrpc2.Conn
+	connMu  sync.RWMutex
+	conn    *jsonrpc2.Conn // replaced on reconnection
+	stream  *closeRecorder // of conn, nil for a ReplaySource, guarded by connMu
+	closed  bool           // guarded by connMu
+	lostAt  time.Time      // when the connection was lost, guarded by connMu
+	lastErr error          // last failure of the connection, guarded by connMu
+
+	reconnecting bool // by WithAutoReconnect, guarded by connMu
+
+	// subMu serializes the changes of the subscriptions with their replay on
+	// reconnection, so that a feed unsubscribed meanwhile is not replayed.
+	subMu sync.Mutex
+
 	updates *responseChannels
+	options wsOptions
+
+	credMu      sync.Mutex
+	credentials *wsCredentials // set once logged in, guarded by credMu
+	rotateMu    sync.Mutex     // serializes the rotations of WithCredentials
+	keyIndex    int            // of the key of WithCredentials, guarded by rotateMu
+	sentOrders  sentOrderIDs   // of the orders placed by PlaceOrder
+	markets     marketCache    // of the orders rounded to their market
+	balances    balanceCache   // last fetched, see GetCurrencyBalance
+	circuit     circuitBreaker // of PlaceOrder, see WithOrderCircuitBreaker
+	symbolNames symbolNames    // of the caller, see WithSymbolNormalizer
+
+	// registered holds the channels registered before subscribing, guarded by subMu.
+	registered map[feedKey]*Subscription
+
+	reconnections chan ReconnectedEvent
+	normalCloses  chan NormalCloseEvent
 }
 
 // NewWSClient creates a new WSClient
-func NewWSClient() (*WSClient, error) {
-	conn, _, err := websocket.DefaultDialer.Dial(wsAPIURL, nil)
+func NewWSClient(opts ...Option) (*WSClient, error) {
+	options := newWSOptions(opts)
+
+	handler := newResponseChannels()
+	handler.raw = options.rawHandler
+	handler.fastDecoding = options.fastDecoding
+	handler.keepRaw = options.rawNotifications
+	handler.kinds = options.kinds
+	if options.overflowFeedSize > 0 {
+		handler.overflows = make(chan OverflowEvent, options.overflowFeedSize)
+	}
+
+	c := &WSClient{
+		updates:       handler,
+		options:       options,
+		reconnections: make(chan ReconnectedEvent, reconnectionsSize),
+		normalCloses:  make(chan NormalCloseEvent, reconnectionsSize),
+	}
+	handler.client = c
+
+	conn, stream, err := c.dial()
 	if err != nil {
 		return nil, err
 	}
+	c.conn = conn
+	c.stream = stream
+	go c.watch(conn, stream)
+
+	if done := options.ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				c.Close()
+			case <-handler.done:
+			}
+		}()
+	}
 
-	handler := responseChannels{
-		notifications: notificationChannels{
-			TickerFeed:    make(map[string]chan WSNotificationTickerResponse),
-			OrderbookFeed: make(map[string]chan WSNotificationOrderbookUpdate),
-			TradesFeed:    make(map[string]chan WSNotificationTradesUpdate),
-			CandlesFeed:   make(map[string]chan WSNotificationCandlesUpdate),
-		},
+	if len(options.credentials) > 0 {
+		if err := c.loginFirstKey(); err != nil {
+			c.Close()
+			return nil, errors.Annotate(err, "Hitbtc login")
+		}
+	}
 
-		OrderbookFeed: make(map[string]chan WSNotificationOrderbookSnapshot),
-		TradesFeed:    make(map[string]chan WSNotificationTradesSnapshot),
-		CandlesFeed:   make(map[string]chan WSNotificationCandlesSnapshot),
+	return c, nil
+}
 
-		ErrorFeed: make(chan error),
+// MustNewWSClient creates a new WSClient as NewWSClient does, and panics if it
+// fails. It is meant for scripts and tests.
+func MustNewWSClient(opts ...Option) *WSClient {
+	c, err := NewWSClient(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// dial opens a new connection to the hitbtc api, handled by the client handler.
+//
+// The stream of a websocket connection is returned as well, to learn how it was
+// closed, nil for a ReplaySource.
+func (c *WSClient) dial() (*jsonrpc2.Conn, *closeRecorder, error) {
+	var connOpts []jsonrpc2.ConnOpt
+	if c.options.recorder != nil {
+		connOpts = append(connOpts, jsonrpc2.OnRecv(c.options.recorder.record))
+	}
+	connOpts = append(connOpts, jsonrpc2.OnRecv(func(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+		if req == nil && resp != nil {
+			c.updates.state.unmatchedResponse()
+		}
+	}))
+	if hook := c.options.callHook; hook != nil {
+		connOpts = append(connOpts, jsonrpc2.OnSend(func(req *jsonrpc2.Request, _ *jsonrpc2.Response) {
+			if req != nil && !req.Notif {
+				hook(req.Method, req.ID.String())
+			}
+		}))
+	}
+	if c.options.replay != nil {
+		stream := c.options.replay.connect(c.updates)
+		return jsonrpc2.NewConn(c.options.ctx, stream, jsonrpc2.AsyncHandler(c.updates), connOpts...), nil, nil
 	}
 
-	return &WSClient{
-		conn:    jsonrpc2.NewConn(context.Background(), jsonrpc2ws.NewObjectStream(conn), jsonrpc2.AsyncHandler(&handler)),
-		updates: &handler,
-	}, nil
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = c.options.subprotocols
+
+	conn, _, err := dialer.DialContext(c.options.ctx, c.options.url, c.options.header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stream := &closeRecorder{ObjectStream: jsonrpc2ws.NewObjectStream(conn), ws: conn}
+	return jsonrpc2.NewConn(c.options.ctx, stream, jsonrpc2.AsyncHandler(c.updates), connOpts...), stream, nil
 }
 
-// Close closes the Websocket connected to the hitbtc api.
-func (c *WSClient) Close() {
-	c.conn.Close()
+// connection returns the current connection to the hitbtc api.
+func (c *WSClient) connection() *jsonrpc2.Conn {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.conn
+}
+
+// isClosed reports whether the client is closed.
+func (c *WSClient) isClosed() bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.closed
+}
 
-	for _, channel := range c.updates.notifications.TickerFeed {
-		close(channel)
+// ResolveSymbol returns the current id of a market, normalizing the symbol with
+// WithSymbolNormalizer and resolving the aliases set with WithSymbolAliases.
+// The symbols of the market data methods and of the subscriptions are
+// resolved, so that the notifications carry the current id, or the symbol of
+// the caller with WithSymbolNormalizer.
+func (c *WSClient) ResolveSymbol(symbol string) string {
+	id := symbol
+	if normalize := c.options.normalizer; normalize != nil {
+		id = normalize(symbol)
 	}
-	for _, channel := range c.updates.notifications.TradesFeed {
-		close(channel)
+	if alias, ok := c.options.aliases[id]; ok {
+		id = alias
 	}
-	for _, channel := range c.updates.notifications.CandlesFeed {
-		close(channel)
+	if c.options.normalizer != nil {
+		c.symbolNames.record(id, symbol)
 	}
-	for _, channel := range c.updates.notifications.OrderbookFeed {
-		close(channel)
+	return id
+}
+
+// validateID checks a market or currency id before sending it, so that a typo
+// fails locally rather than with an opaque error of hitbtc: the ids are non
+// empty UTF-8 strings, uppercase and without spaces.
+func validateID(field, id string) error {
+	if id == "" {
+		return errors.NotValidf("empty %s", field)
 	}
-	for _, channel := range c.updates.OrderbookFeed {
-		close(channel)
+	if !utf8.ValidString(id) {
+		return errors.NotValidf("%s %q", field, id)
 	}
-	for _, channel := range c.updates.TradesFeed {
-		close(channel)
+	for _, r := range id {
+		if unicode.IsSpace(r) || unicode.IsLower(r) {
+			return errors.NotValidf("%s %q", field, id)
+		}
 	}
-	for _, channel := range c.updates.CandlesFeed {
-		close(channel)
+	return nil
+}
+
+// annotate annotates the error of an operation, leaving ErrClientClosed as is.
+func annotate(err error, op string) error {
+	if err == nil || err == ErrClientClosed {
+		return err
 	}
+	return errors.Annotate(err, op)
+}
+
+// call performs a market data JSON RPC call bounded by the read timeout of the client.
+func (c *WSClient) call(method string, params, result interface{}) error {
+	return c.callContext(context.Background(), method, params, result)
+}
 
-	close(c.updates.ErrorFeed)
+// callContext performs a market data call, bounded by ctx and by the timeout
+// of the market data calls.
+func (c *WSClient) callContext(ctx context.Context, method string, params, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.options.timeout(readMethod))
+	defer cancel()
+
+	return c.rpc(ctx, method, params, result)
+}
+
+// rpc performs a JSON RPC call on the current connection. A call without
+// response before the deadline of ctx fails with ErrResponseTimeout, a call
+// after Close with ErrClientClosed.
+func (c *WSClient) rpc(ctx context.Context, method string, params, result interface{}) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	err := c.connection().Call(ctx, method, params, result)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &responseTimeoutError{method: method}
+	}
+	return err
+}
 
-	c.updates.notifications.TickerFeed = make(map[string]chan WSNotificationTickerResponse)
-	c.updates.notifications.TradesFeed = make(map[string]chan WSNotificationTradesUpdate)
-	c.updates.notifications.OrderbookFeed = make(map[string]chan WSNotificationOrderbookUpdate)
-	c.updates.notifications.CandlesFeed = make(map[string]chan WSNotificationCandlesUpdate)
-	c.updates.CandlesFeed = make(map[string]chan WSNotificationCandlesSnapshot)
-	c.updates.TradesFeed = make(map[string]chan WSNotificationTradesSnapshot)
-	c.updates.OrderbookFeed = make(map[string]chan WSNotificationOrderbookSnapshot)
-	c.updates.ErrorFeed = make(chan error)
+// responseTimeoutError is the error of a call without response before its
+// deadline, matching both ErrResponseTimeout and context.DeadlineExceeded.
+type responseTimeoutError struct {
+	method string
+}
+
+func (e *responseTimeoutError) Error() string {
+	return e.method + ": " + ErrResponseTimeout.Error()
+}
+
+func (e *responseTimeoutError) Is(target error) bool {
+	return target == ErrResponseTimeout || target == context.DeadlineExceeded
+}
+
+// Close closes the Websocket connected to the hitbtc api.
+//
+// The methods calling hitbtc after Close return ErrClientClosed, closing
+// again does nothing.
+func (c *WSClient) Close() {
+	c.connMu.Lock()
+	if c.closed {
+		c.connMu.Unlock()
+		return
+	}
+	c.closed = true
+	conn := c.conn
+	c.connMu.Unlock()
+
+	// stop the reader first, so that no notification is handled after the channels are closed.
+	conn.Close()
+	<-conn.DisconnectNotify()
+	c.updates.closeAll()
+	c.closeRegistered()
+}
+
+// OverflowEvent is a notification dropped by a non-blocking delivery: an order
+// book update merged into the next one by WithCoalescing, or an active orders
+// snapshot sent while the previous one was not read.
+type OverflowEvent struct {
+	Symbol     string
+	FeedKind   FeedKind
+	DroppedSeq int64 // sequence of the dropped order book update, zero otherwise
+}
+
+// OverflowFeed returns the notifications dropped by the non-blocking deliveries,
+// when enabled with WithOverflowFeed, nil otherwise. It is never closed.
+func (c *WSClient) OverflowFeed() <-chan OverflowEvent {
+	return c.updates.overflows
+}
+
+// Done returns a channel that is closed when the connection to the hitbtc api
+// is lost or closed.
+func (c *WSClient) Done() <-chan struct{} {
+	return c.connection().DisconnectNotify()
 }
 
 // WSGetCurrencyRequest is get currency request type on websocket
@@ -191,16 +795,43 @@ type WSGetCurrencyResponse struct {
 
 // GetCurrencyInfo get the info about a currency.
 func (c *WSClient) GetCurrencyInfo(symbol string) (*WSGetCurrencyResponse, error) {
+	if err := validateID("currency", symbol); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetCurrency")
+	}
 	var request = WSGetCurrencyRequest{Currency: symbol}
 	var response WSGetCurrencyResponse
 
-	err := c.conn.Call(context.Background(), "getCurrency", request, &response)
+	err := c.call("getCurrency", request, &response)
 	if err != nil {
 		return nil, errors.Annotate(err, "Hitbtc GetCurrency")
 	}
 	return &response, nil
 }
 
+// GetCurrencies obtains the info about all the currencies.
+func (c *WSClient) GetCurrencies() ([]WSGetCurrencyResponse, error) {
+	var response []WSGetCurrencyResponse
+
+	err := c.call("getCurrencies", struct{}{}, &response)
+	if err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetCurrencies")
+	}
+	return response, nil
+}
+
+// GetCurrenciesMap obtains the info about all the currencies, keyed by id.
+func (c *WSClient) GetCurrenciesMap() (map[string]WSGetCurrencyResponse, error) {
+	currencies, err := c.GetCurrencies()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]WSGetCurrencyResponse, len(currencies))
+	for _, currency := range currencies {
+		m[currency.ID] = currency
+	}
+	return m, nil
+}
+
 // WSGetSymbolRequest is get symbols request type on websocket
 type WSGetSymbolRequest struct {
 	Symbol string `json:"symbol"`
@@ -216,20 +847,78 @@ type WSGetSymbolResponse struct {
 	TakeLiquidityRate    string `json:"takeLiquidityRate"`
 	ProvideLiquidityRate string `json:"provideLiquidityRate"`
 	FeeCurrency          string `json:"feeCurrency"`
+	MinQuantity          string `json:"minQuantity,omitempty"` // Minimum order quantity, when sent by hitbtc
+	MaxPosition          string `json:"maxPosition,omitempty"` // Maximum position, when sent by hitbtc
+}
+
+// MinOrderQuantity returns the minimum quantity of an order on the market: the
+// minimum quantity when hitbtc sends it, the quantity increment otherwise.
+func (s WSGetSymbolResponse) MinOrderQuantity() string {
+	if s.MinQuantity != "" {
+		return s.MinQuantity
+	}
+	return s.QuantityIncrement
 }
 
 // GetSymbol obtains the data of a market.
 func (c *WSClient) GetSymbol(symbol string) (*WSGetSymbolResponse, error) {
-	var request = WSGetSymbolRequest{Symbol: symbol}
+	var request = WSGetSymbolRequest{Symbol: c.ResolveSymbol(symbol)}
+	if err := validateID("symbol", request.Symbol); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetSymbol")
+	}
 	var response WSGetSymbolResponse
 
-	err := c.conn.Call(context.Background(), "getSymbol", request, &response)
+	err := c.call("getSymbol", request, &response)
 	if err != nil {
 		return nil, errors.Annotate(err, "Hitbtc GetSymbol")
 	}
 	return &response, nil
 }
 
+// GetSymbols obtains the data of all the markets.
+func (c *WSClient) GetSymbols() ([]WSGetSymbolResponse, error) {
+	var response []WSGetSymbolResponse
+
+	err := c.call("getSymbols", struct{}{}, &response)
+	if err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetSymbols")
+	}
+	return response, nil
+}
+
+// GetSymbolsMap obtains the data of all the markets, keyed by id.
+func (c *WSClient) GetSymbolsMap() (map[string]WSGetSymbolResponse, error) {
+	symbols, err := c.GetSymbols()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]WSGetSymbolResponse, len(symbols))
+	for _, symbol := range symbols {
+		m[symbol.ID] = symbol
+	}
+	return m, nil
+}
+
+// WSGetTickerRequest is get ticker request type on websocket
+type WSGetTickerRequest struct {
+	Symbol string `json:"symbol"`
+}
+
+// GetTicker obtains the current ticker of a market without subscribing to it.
+func (c *WSClient) GetTicker(symbol string) (*WSNotificationTickerResponse, error) {
+	var request = WSGetTickerRequest{Symbol: c.ResolveSymbol(symbol)}
+	if err := validateID("symbol", request.Symbol); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetTicker")
+	}
+	var response WSNotificationTickerResponse
+
+	err := c.call("getTicker", request, &response)
+	if err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetTicker")
+	}
+	return &response, nil
+}
+
 // WSGetTradesRequest is get trades request type on websocket
 type WSGetTradesRequest struct {
 	Symbol string     `json:"symbol"`
@@ -246,20 +935,42 @@ type WSGetTradesResponse struct {
 	Data []WSTrades `json:"data"`
 }
 
-// GetTrades obtains the data of a series of trades, based on the specified filters.
+// GetTrades obtains the last DefaultTradesLimit trades of the market, the
+// most recent first. The trades are filtered by time or trade id range, sorted
+// and paged with QueryTrades, or walked through with TradesIterator.
 func (c *WSClient) GetTrades(symbol string) (*WSGetTradesResponse, error) {
-	var request = WSGetTradesRequest{Symbol: symbol}
+	var request = WSGetTradesRequest{Symbol: c.ResolveSymbol(symbol), Limit: DefaultTradesLimit, Sort: SortDesc, By: ByTimestamp}
+	if err := validateID("symbol", request.Symbol); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetTrades")
+	}
 	var response WSGetTradesResponse
 
-	err := c.conn.Call(context.Background(), "getSymbol", request, &response)
+	err := c.call("getTrades", request, &response)
 	if err != nil {
-		return nil, errors.Annotate(err, "Hitbtc GetSymbol")
+		return nil, errors.Annotate(err, "Hitbtc GetTrades")
 	}
 	return &response, nil
 }
 
-// wsSubscriptionResponse is the response for a subscribe/unsubscribe requests.
-type wsSubscriptionResponse bool
+// wsSubscriptionResponse is the response for a subscribe/unsubscribe requests:
+// a boolean, or an object echoing the subscribed channel and params.
+type wsSubscriptionResponse struct {
+	success bool
+	echo    json.RawMessage // nil for a boolean response
+}
+
+func (r *wsSubscriptionResponse) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.success); err == nil {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return errors.NotValidf("subscription response %s", data)
+	}
+	r.success = true
+	r.echo = append(json.RawMessage(nil), data...)
+	return nil
+}
 
 // WSSubscriptionRequest is request type on websocket subscription.
 type WSSubscriptionRequest struct {
@@ -276,49 +987,55 @@ type WSNotificationTickerResponse struct {
 	High        string `json:"high"`        // Highest trade price within 24 hours
 	Volume      string `json:"volume"`      // Total trading amount within 24 hours in base currency
 	VolumeQuote string `json:"volumeQuote"` // Total trading amount within 24 hours in quote currency
-	Timestamp   string `json:"timestamp"`   // Last update or refresh ticker timestamp
+	Timestamp   WSTime `json:"timestamp"`   // Last update or refresh ticker timestamp
 	Symbol      string `json:"symbol"`
-}
 
-// SubscribeTicker subscribes to the specified market ticker notifications.
-func (c *WSClient) SubscribeTicker(symbol string) (<-chan WSNotificationTickerResponse, error) {
-	err := c.subscriptionOp("subscribeTicker", symbol)
-	if err != nil {
-		return nil, errors.Annotate(err, "Hitbtc SubscribeTicker")
-	}
-
-	if c.updates.notifications.TickerFeed[symbol] == nil {
-		c.updates.notifications.TickerFeed[symbol] = make(chan WSNotificationTickerResponse)
-	}
+	// Raw holds the params of the notification as received, see WithRawNotifications.
+	Raw json.RawMessage `json:"-"`
+}
 
-	return c.updates.notifications.TickerFeed[symbol], nil
+// WSTickerSubscriptionRequest is request type on websocket ticker subscription.
+type WSTickerSubscriptionRequest struct {
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval,omitempty"`
 }
 
-// UnsubscribeTicker subscribes to the specified market ticker notifications.
+// SubscribeTicker subscribes to the specified market ticker notifications.
 //
-// This closes also the connected channel of updates.
-func (c *WSClient) UnsubscribeTicker(symbol string) error {
-	err := c.subscriptionOp("unsubscribeTicker", symbol)
+// Pass WithTickerInterval to receive the ticker at a slower cadence.
+func (c *WSClient) SubscribeTicker(symbol string, opts ...SubOption) (<-chan WSNotificationTickerResponse, error) {
+	s, err := c.subscribe(FeedTicker, symbol, opts)
 	if err != nil {
-		return errors.Annotate(err, "Hitbtc UnsubscribeTicker")
+		return nil, annotate(err, "Hitbtc SubscribeTicker")
 	}
+	return s.ticker, nil
+}
 
-	close(c.updates.notifications.TickerFeed[symbol])
-	delete(c.updates.notifications.TickerFeed, symbol)
-
-	return nil
+// UnsubscribeTicker unsubscribes from the specified market ticker notifications.
+//
+// This closes also the connected channel of updates. It reports whether the
+// ticker was subscribed, nothing is sent to hitbtc otherwise.
+func (c *WSClient) UnsubscribeTicker(symbol string) (bool, error) {
+	ok, err := c.unsubscribe(context.Background(), FeedTicker, symbol)
+	return ok, annotate(err, "Hitbtc UnsubscribeTicker")
 }
 
 // WSNotificationTradesSnapshot is notification response type to trades on websocket
 type WSNotificationTradesSnapshot struct {
 	Data   []WSTrades `json:"data"`
 	Symbol string     `json:"symbol"`
+
+	// Raw holds the params of the notification as received, see WithRawNotifications.
+	Raw json.RawMessage `json:"-"`
 }
 
 // WSNotificationTradesUpdate is notification response type to trades on websocket
 type WSNotificationTradesUpdate struct {
 	Data   WSTrades `json:"data"`
 	Symbol string   `json:"symbol"`
+
+	// Raw holds the params of the notification as received, see WithRawNotifications.
+	Raw json.RawMessage `json:"-"`
 }
 
 // WSTrades is item for Trades
@@ -327,41 +1044,33 @@ type WSTrades struct {
 	Price     string `json:"price"`
 	Quantity  string `json:"quantity"`
 	Side      string `json:"side"`
-	Timestamp string `json:"timestamp"`
+	Timestamp WSTime `json:"timestamp"`
+}
+
+// WSTradesSubscriptionRequest is request type on websocket trades subscription.
+type WSTradesSubscriptionRequest struct {
+	Symbol string `json:"symbol"`
+	Limit  int    `json:"limit,omitempty"` // trades of the snapshot, the server default when zero
 }
 
 // SubscribeTrades subscribes to the specified market trades notifications.
-func (c *WSClient) SubscribeTrades(symbol string) (<-chan WSNotificationTradesUpdate, <-chan WSNotificationTradesSnapshot, error) {
-	err := c.subscriptionOp("subscribeTrades", symbol)
+//
+// Pass WithTradesLimit to set the number of trades of the initial snapshot.
+func (c *WSClient) SubscribeTrades(symbol string, opts ...SubOption) (<-chan WSNotificationTradesUpdate, <-chan WSNotificationTradesSnapshot, error) {
+	s, err := c.subscribe(FeedTrades, symbol, opts)
 	if err != nil {
-		return nil, nil, errors.Annotate(err, "Hitbtc SubscribeTrades")
-	}
-
-	if c.updates.notifications.TradesFeed[symbol] == nil {
-		c.updates.notifications.TradesFeed[symbol] = make(chan WSNotificationTradesUpdate)
-	}
-	if c.updates.TradesFeed[symbol] == nil {
-		c.updates.TradesFeed[symbol] = make(chan WSNotificationTradesSnapshot)
+		return nil, nil, annotate(err, "Hitbtc SubscribeTrades")
 	}
-
-	return c.updates.notifications.TradesFeed[symbol], c.updates.TradesFeed[symbol], nil
+	return s.tradesUpdates, s.tradesSnapshots, nil
 }
 
 // UnsubscribeTrades unsubscribes from the specified market trades notifications and snapshot.
 //
-// This closes also the connected channel of updates.
-func (c *WSClient) UnsubscribeTrades(symbol string) error {
-	err := c.subscriptionOp("unsubscribeTrades", symbol)
-	if err != nil {
-		return errors.Annotate(err, "Hitbtc UnsubscribeTrades")
-	}
-
-	close(c.updates.notifications.TradesFeed[symbol])
-	delete(c.updates.notifications.TradesFeed, symbol)
-	close(c.updates.TradesFeed[symbol])
-	delete(c.updates.TradesFeed, symbol)
-
-	return nil
+// This closes also the connected channel of updates. It reports whether the
+// feed was subscribed.
+func (c *WSClient) UnsubscribeTrades(symbol string) (bool, error) {
+	ok, err := c.unsubscribe(context.Background(), FeedTrades, symbol)
+	return ok, annotate(err, "Hitbtc UnsubscribeTrades")
 }
 
 // WSSubtypeTrade is element of market trade type
@@ -376,6 +1085,9 @@ type WSNotificationOrderbookSnapshot struct {
 	Bid      []WSSubtypeTrade `json:"bid"`
 	Symbol   string           `json:"symbol"`
 	Sequence int64            `json:"sequence"` // used to see if update is the latest received
+
+	// Raw holds the params of the notification as received, see WithRawNotifications.
+	Raw json.RawMessage `json:"-"`
 }
 
 // WSNotificationOrderbookUpdate is notification response type to orderbook snapshot on websocket
@@ -384,40 +1096,48 @@ type WSNotificationOrderbookUpdate struct {
 	Bid      []WSSubtypeTrade `json:"bid"`
 	Symbol   string           `json:"symbol"`
 	Sequence int64            `json:"sequence"` // used to see if the snapshot is the latest
+
+	// Raw holds the params of the notification as received, see
+	// WithRawNotifications. It is nil for a coalesced update.
+	Raw json.RawMessage `json:"-"`
 }
 
 // SubscribeOrderbook subscribes to the specified market order book notifications.
-func (c *WSClient) SubscribeOrderbook(symbol string) (<-chan WSNotificationOrderbookUpdate, <-chan WSNotificationOrderbookSnapshot, error) {
-	err := c.subscriptionOp("subscribeOrderbook", symbol)
+//
+// Pass WithCoalescing to receive merged updates when the consumer falls behind.
+//
+// A snapshot with the same sequence as the previous one, as hitbtc may send on
+// reconnection, is dropped.
+func (c *WSClient) SubscribeOrderbook(symbol string, opts ...SubOption) (<-chan WSNotificationOrderbookUpdate, <-chan WSNotificationOrderbookSnapshot, error) {
+	s, err := c.subscribe(FeedOrderbook, symbol, opts)
 	if err != nil {
-		return nil, nil, errors.Annotate(err, "Hitbtc SubscribeOrderbook")
+		return nil, nil, annotate(err, "Hitbtc SubscribeOrderbook")
 	}
-
-	if c.updates.notifications.OrderbookFeed[symbol] == nil {
-		c.updates.notifications.OrderbookFeed[symbol] = make(chan WSNotificationOrderbookUpdate)
-	}
-	if c.updates.OrderbookFeed[symbol] == nil {
-		c.updates.OrderbookFeed[symbol] = make(chan WSNotificationOrderbookSnapshot)
-	}
-
-	return c.updates.notifications.OrderbookFeed[symbol], c.updates.OrderbookFeed[symbol], nil
+	return s.orderbookUpdates, s.orderbookSnapshots, nil
 }
 
-// UnsubscribeOrderbook unsubscribes from the specified market order book notifications and snapshot.
+// SubscribeOrderbookWithBook subscribes to the specified market order book
+// notifications, as SubscribeOrderbook, and returns the order book maintained
+// from them by the client as well.
 //
-// This closes also the connected channel of updates.
-func (c *WSClient) UnsubscribeOrderbook(symbol string) error {
-	err := c.subscriptionOp("unsubscribeOrderbook", symbol)
+// The book is updated before the notifications are delivered, so the
+// channels must still be read. A feed already subscribed without the book
+// returns ErrSubscriptionConflict: unsubscribe first.
+func (c *WSClient) SubscribeOrderbookWithBook(symbol string, opts ...SubOption) (<-chan WSNotificationOrderbookUpdate, <-chan WSNotificationOrderbookSnapshot, *OrderBook, error) {
+	s, err := c.subscribe(FeedOrderbook, symbol, append([]SubOption{WithMaintainedOrderBook()}, opts...))
 	if err != nil {
-		return errors.Annotate(err, "Hitbtc UnsubscribeOrderbook")
+		return nil, nil, nil, annotate(err, "Hitbtc SubscribeOrderbookWithBook")
 	}
+	return s.orderbookUpdates, s.orderbookSnapshots, s.book, nil
+}
 
-	close(c.updates.notifications.OrderbookFeed[symbol])
-	delete(c.updates.notifications.OrderbookFeed, symbol)
-	close(c.updates.OrderbookFeed[symbol])
-	delete(c.updates.OrderbookFeed, symbol)
-
-	return nil
+// UnsubscribeOrderbook unsubscribes from the specified market order book notifications and snapshot.
+//
+// This closes also the connected channel of updates. It reports whether the
+// feed was subscribed.
+func (c *WSClient) UnsubscribeOrderbook(symbol string) (bool, error) {
+	ok, err := c.unsubscribe(context.Background(), FeedOrderbook, symbol)
+	return ok, annotate(err, "Hitbtc UnsubscribeOrderbook")
 }
 
 const (
@@ -438,6 +1158,9 @@ type WSNotificationCandlesSnapshot struct {
 	Data   []WSCandles `json:"data"`
 	Symbol string      `json:"symbol"`
 	Period string      `json:"period"`
+
+	// Raw holds the params of the notification as received, see WithRawNotifications.
+	Raw json.RawMessage `json:"-"`
 }
 
 // WSNotificationCandlesUpdate is subscribe response type to candles on websocket
@@ -445,82 +1168,81 @@ type WSNotificationCandlesUpdate struct {
 	Data   WSCandles `json:"data"`
 	Symbol string    `json:"symbol"`
 	Period string    `json:"period"`
+
+	// Raw holds the params of the notification as received, see
+	// WithRawNotifications. With WithClosedCandles, it is the notification of
+	// the next candle, which closed the delivered one.
+	Raw json.RawMessage `json:"-"`
 }
 
-// WSCandles is item for WSCandles
+// WSCandles is a candle of a market, see VolumePerBar.
 type WSCandles struct {
-	Timestamp   time.Time `json:"timestamp"`
-	Open        string    `json:"open"`
-	Close       string    `json:"close"`
-	Min         string    `json:"min"`
-	Max         string    `json:"max"`
-	Volume      string    `json:"volume"`      // Total trading amount within 24 hours in base currency
-	VolumeQuote string    `json:"volumeQuote"` // Total trading amount within 24 hours in quote currency
+	Timestamp   WSTime `json:"timestamp"`
+	Open        string `json:"open"`
+	Close       string `json:"close"`
+	Min         string `json:"min"`
+	Max         string `json:"max"`
+	Volume      string `json:"volume"`      // Amount traded during the period of the candle in base currency
+	VolumeQuote string `json:"volumeQuote"` // Amount traded during the period of the candle in quote currency
 }
 
 // SubscribeCandles subscribes to the specified market candle notifications for the specified timeframe.
-func (c *WSClient) SubscribeCandles(symbol string, timeframe string) (<-chan WSNotificationCandlesUpdate, <-chan WSNotificationCandlesSnapshot, error) {
-	err := c.candlesSubscriptionOp("subscribeCandles", symbol, timeframe)
+//
+// With WithClosedCandles the updates only carry the completed candles, see
+// also SubscribeCandleBars.
+func (c *WSClient) SubscribeCandles(symbol string, timeframe string, opts ...SubOption) (<-chan WSNotificationCandlesUpdate, <-chan WSNotificationCandlesSnapshot, error) {
+	s, err := c.subscribe(FeedCandles, symbol, append([]SubOption{WithPeriod(timeframe)}, opts...))
 	if err != nil {
-		return nil, nil, errors.Annotate(err, "Hitbtc SubscribeCandles")
+		return nil, nil, annotate(err, "Hitbtc SubscribeCandles")
 	}
-
-	if c.updates.notifications.CandlesFeed[symbol] == nil {
-		c.updates.notifications.CandlesFeed[symbol] = make(chan WSNotificationCandlesUpdate)
-	}
-
-	if c.updates.CandlesFeed[symbol] == nil {
-		c.updates.CandlesFeed[symbol] = make(chan WSNotificationCandlesSnapshot)
-	}
-
-	return c.updates.notifications.CandlesFeed[symbol], c.updates.CandlesFeed[symbol], nil
+	return s.candlesUpdates, s.candlesSnapshots, nil
 }
 
 // UnsubscribeCandles unsubscribes from the specified market candle notifications for the specified timeframe.
 //
-// This closes also the connected channel of updates.
-func (c *WSClient) UnsubscribeCandles(symbol string, timeframe string) error {
-	err := c.candlesSubscriptionOp("unsubscribeCandles", symbol, timeframe)
-	if err != nil {
-		return errors.Annotate(err, "Hitbtc UnsubscribeCandles")
+// This closes also the connected channel of updates. It reports whether the
+// feed was subscribed for the timeframe.
+func (c *WSClient) UnsubscribeCandles(symbol string, timeframe string) (bool, error) {
+	if s := c.updates.lookup(FeedCandles, c.ResolveSymbol(symbol)); s != nil && s.opts.period != timeframe {
+		return false, nil
 	}
+	ok, err := c.unsubscribe(context.Background(), FeedCandles, symbol)
+	return ok, annotate(err, "Hitbtc UnsubscribeCandles")
+}
 
-	close(c.updates.notifications.CandlesFeed[symbol])
-	delete(c.updates.notifications.CandlesFeed, symbol)
-	close(c.updates.CandlesFeed[symbol])
-	delete(c.updates.CandlesFeed, symbol)
-
-	return nil
+func (c *WSClient) subscriptionOp(ctx context.Context, op string, symbol string) (json.RawMessage, error) {
+	return c.requestSubscriptionOp(ctx, op, WSSubscriptionRequest{Symbol: symbol})
 }
 
-func (c *WSClient) subscriptionOp(op string, symbol string) error {
-	if c.conn == nil {
-		return errors.New("Connection is unitialized")
+// requestSubscriptionOp performs a subscribe/unsubscribe call, returning the
+// params echoed by hitbtc if any.
+func (c *WSClient) requestSubscriptionOp(ctx context.Context, op string, request interface{}) (json.RawMessage, error) {
+	if c.connection() == nil {
+		return nil, errors.New("Connection is unitialized")
 	}
 
-	var request = WSSubscriptionRequest{Symbol: symbol}
-	var success wsSubscriptionResponse
+	var response wsSubscriptionResponse
 
-	err := c.conn.Call(context.Background(), op, request, &success)
+	err := c.callContext(ctx, op, request, &response)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if !success {
-		return errors.New("Subscribe not successful")
+	if !response.success {
+		return nil, errors.New("Subscribe not successful")
 	}
 
-	return nil
+	return response.echo, nil
 }
 
-func (c *WSClient) candlesSubscriptionOp(op string, symbol string, period string) error {
+func (c *WSClient) candlesSubscriptionOp(ctx context.Context, op string, symbol string, period string) (json.RawMessage, error) {
 	var request = WSCandlesSubscriptionRequest{Symbol: symbol, Period: period}
 	var response wsSubscriptionResponse
 
-	err := c.conn.Call(context.Background(), op, request, &response)
+	err := c.callContext(ctx, op, request, &response)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return response.echo, nil
 }
@@ -0,0 +1,219 @@
+package hitbtc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/juju/errors"
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
+)
+
+// wsCredentials are the API keys used to authenticate the websocket session.
+type wsCredentials struct {
+	apiKey    string
+	secretKey string
+}
+
+// Login algorithms of WSLoginRequest.
+const (
+	LoginBasic = "BASIC"
+	LoginHS256 = "HS256" // see WithHMACLogin
+)
+
+// WSLoginRequest is login request type on websocket
+type WSLoginRequest struct {
+	Algo      string `json:"algo"`
+	PKey      string `json:"pKey"`
+	SKey      string `json:"sKey,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"` // hex HMAC-SHA256 of the nonce with the secret key
+}
+
+// Login authenticates the websocket session, allowing the trading and account methods.
+//
+// The credentials are kept to log in again when the authorization expires, see WithAutoRelogin.
+// The errors reported by hitbtc, e.g. an unknown key (code 1002) or a key not
+// allowed to log in (CodeActionForbidden), are returned as *APIError.
+func (c *WSClient) Login(apiKey, secretKey string) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.defaultTimeout)
+	defer cancel()
+
+	credentials := wsCredentials{apiKey: apiKey, secretKey: secretKey}
+	if err := c.login(ctx, credentials); err != nil {
+		return errors.Annotate(wsAPIError(err), "Hitbtc Login")
+	}
+	c.setSession(&credentials)
+	return nil
+}
+
+func (c *WSClient) login(ctx context.Context, credentials wsCredentials) error {
+	var request = WSLoginRequest{Algo: LoginBasic, PKey: credentials.apiKey, SKey: credentials.secretKey}
+	if c.options.hmacLogin {
+		request = signLogin(credentials, NewClientOrderID())
+	}
+	var success wsSubscriptionResponse
+
+	err := c.rpc(ctx, "login", request, &success)
+	if err != nil {
+		return err
+	}
+	if !success.success {
+		return errors.New("Login not successful")
+	}
+	return nil
+}
+
+// signLogin returns the HS256 login request of the credentials, the secret
+// key signing the nonce rather than being sent.
+func signLogin(credentials wsCredentials, nonce string) WSLoginRequest {
+	mac := hmac.New(sha256.New, []byte(credentials.secretKey))
+	mac.Write([]byte(nonce))
+	return WSLoginRequest{
+		Algo:      LoginHS256,
+		PKey:      credentials.apiKey,
+		Nonce:     nonce,
+		Signature: hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+// privateCall performs a JSON RPC call requiring an authenticated session.
+//
+// When hitbtc reports that the authorization of a logged in session expired,
+// the session is logged in again and the call retried once. The same holds
+// with the next key when the key is rate limited or not allowed the action
+// and several keys are set, see WithCredentials.
+func (c *WSClient) privateCall(ctx context.Context, method string, params, result interface{}) error {
+	used := c.session()
+	err := c.rpc(ctx, method, params, result)
+	if err != nil && used != nil && len(c.options.credentials) > 1 && isKeyExhausted(err) {
+		if err := c.rotate(ctx, used); err != nil {
+			return errors.Annotate(err, "rotate credentials")
+		}
+		return c.rpc(ctx, method, params, result)
+	}
+	if err == nil || !c.options.autoRelogin || used == nil || !isAuthExpired(err) {
+		return err
+	}
+
+	if err := c.login(ctx, *used); err != nil {
+		return errors.Annotate(err, "relogin")
+	}
+	return c.rpc(ctx, method, params, result)
+}
+
+// Call performs a JSON RPC call of any method of the api into result, e.g. a
+// method of another endpoint set with WithURL, such as the derivatives one,
+// that has no wrapper in the client. The call is retried once after logging in
+// again as for the trading methods, see WithAutoRelogin.
+//
+// The errors reported by hitbtc are returned as *APIError.
+func (c *WSClient) Call(ctx context.Context, method string, params, result interface{}) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.options.defaultTimeout)
+	defer cancel()
+
+	err := c.privateCall(ctx, method, params, result)
+	if err != nil {
+		return errors.Annotatef(wsAPIError(err), "Hitbtc Call %s", method)
+	}
+	return nil
+}
+
+// Ready checks that the client can serve requests: the connection is up and
+// answers, and the session is authenticated if Login was called. It performs
+// a cheap call, getTradingBalance when logged in and getCurrency otherwise.
+func (c *WSClient) Ready(ctx context.Context) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	select {
+	case <-c.connection().DisconnectNotify():
+		return errors.New("Hitbtc Ready: disconnected")
+	default:
+	}
+
+	var result json.RawMessage
+	var err error
+	if c.session() != nil {
+		err = c.privateCall(ctx, "getTradingBalance", struct{}{}, &result)
+	} else {
+		err = c.rpc(ctx, "getCurrency", WSGetCurrencyRequest{Currency: "BTC"}, &result)
+	}
+	return annotate(err, "Hitbtc Ready")
+}
+
+// Permissions are the operations allowed to the API key of the session.
+type Permissions struct {
+	TradingBalance bool // reading the trading balance and the active orders
+	PlaceOrders    bool // placing, replacing and canceling orders
+}
+
+// CheckPermissions reports the operations allowed to the API key of the
+// session, so that a key without trading permission is detected at startup.
+// The session must be authenticated.
+//
+// Each permission is probed with a call without effect, getTradingBalance and
+// the cancellation of an order that does not exist, and is missing when hitbtc
+// answers with CodeActionForbidden. With several keys set by WithCredentials,
+// a forbidden probe rotates the key, as for any call.
+func (c *WSClient) CheckPermissions() (Permissions, error) {
+	if c.isClosed() {
+		return Permissions{}, ErrClientClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.defaultTimeout)
+	defer cancel()
+
+	var permissions Permissions
+	var err error
+	permissions.TradingBalance, err = c.probe(ctx, "getTradingBalance", struct{}{})
+	if err != nil {
+		return Permissions{}, errors.Annotate(err, "Hitbtc CheckPermissions")
+	}
+	permissions.PlaceOrders, err = c.probe(ctx, "cancelOrder", wsCancelOrderRequest{ClientOrderID: NewClientOrderID()})
+	if err != nil {
+		return Permissions{}, errors.Annotate(err, "Hitbtc CheckPermissions")
+	}
+	return permissions, nil
+}
+
+// wsCancelOrderRequest is the request of cancelOrder.
+type wsCancelOrderRequest struct {
+	ClientOrderID string `json:"clientOrderId"`
+}
+
+// probe reports whether the method is allowed to the API key: any answer of
+// hitbtc but CodeActionForbidden means it is, as the params of a probe are not
+// expected to be valid.
+func (c *WSClient) probe(ctx context.Context, method string, params interface{}) (bool, error) {
+	var result json.RawMessage
+	err := c.privateCall(ctx, method, params, &result)
+	var rpcErr *jsonrpc2.Error
+	switch {
+	case err == nil:
+		return true, nil
+	case !errors.As(err, &rpcErr) || isAuthExpired(err):
+		return false, errors.Annotate(wsAPIError(err), method)
+	}
+	return rpcErr.Code != CodeActionForbidden, nil
+}
+
+// isAuthExpired reports whether err is one of the "Authorization required" errors.
+func isAuthExpired(err error) bool {
+	var rpcErr *jsonrpc2.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	return rpcErr.Code == 1001 || rpcErr.Code == 1002
+}
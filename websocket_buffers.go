@@ -0,0 +1,91 @@
+package hitbtc
+
+import "encoding/json"
+
+// reusesBuffers reports whether the order book updates of the subscription are
+// decoded into its buffers, see WithReusedBuffers.
+func (s *Subscription) reusesBuffers() bool {
+	return s.kind == FeedOrderbook && s.opts.reuseBuffers && s.coalescer == nil
+}
+
+// reusingBuffers reports whether any subscription reuses its buffers.
+func (h *responseChannels) reusingBuffers() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.reusing > 0
+}
+
+// handleReusedUpdate delivers an order book update decoded into the buffers of
+// its subscription, made with WithReusedBuffers. It reports whether the update
+// was handled, the other updates being decoded as usual.
+func (h *responseChannels) handleReusedUpdate(message json.RawMessage) bool {
+	if !h.reusingBuffers() {
+		return false
+	}
+	var peek struct {
+		Symbol string `json:"symbol"`
+	}
+	if err := json.Unmarshal(message, &peek); err != nil {
+		return false
+	}
+	f := h.acquire(FeedOrderbook, peek.Symbol)
+	if f == nil {
+		return false
+	}
+	defer f.inflight.Done()
+	if !f.reusesBuffers() {
+		return false
+	}
+
+	// the buffers are used by a single delivery at a time, the other one
+	// being held by the consumer until it reads the next update.
+	f.buffersMu.Lock()
+	defer f.buffersMu.Unlock()
+	msg := &f.buffers[f.nextBuffer]
+	*msg = WSNotificationOrderbookUpdate{Ask: msg.Ask[:0], Bid: msg.Bid[:0]}
+	if err := json.Unmarshal(message, msg); err != nil {
+		h.fail(FeedOrderbook, message, err)
+		return true
+	}
+
+	msg.Raw = h.rawOf(message)
+	h.state.sequence(msg.Symbol, msg.Sequence)
+	if f.book != nil {
+		if err := f.book.ApplyUpdate(*msg); err != nil {
+			f.report(&SymbolError{Symbol: msg.Symbol, FeedKind: FeedOrderbook, Err: err})
+		}
+	}
+	if h.held(FeedOrderbook, msg.Symbol, msg.Sequence) {
+		return true
+	}
+	msg.Symbol = h.symbolName(msg.Symbol)
+	select {
+	case f.orderbookUpdates <- *msg:
+		f.nextBuffer = 1 - f.nextBuffer
+	case <-f.done:
+	}
+	return true
+}
+
+// Clone returns a copy of the snapshot owning its level slices, which can be
+// retained while the snapshot is modified.
+func (s WSNotificationOrderbookSnapshot) Clone() WSNotificationOrderbookSnapshot {
+	s.Ask, s.Bid = cloneLevels(s.Ask), cloneLevels(s.Bid)
+	return s
+}
+
+// Clone returns a copy of the update owning its level slices, which can be
+// retained past the next read of an update decoded into reused buffers, see
+// WithReusedBuffers.
+func (u WSNotificationOrderbookUpdate) Clone() WSNotificationOrderbookUpdate {
+	u.Ask, u.Bid = cloneLevels(u.Ask), cloneLevels(u.Bid)
+	return u
+}
+
+// cloneLevels copies the levels, keeping a nil slice nil.
+func cloneLevels(levels []WSSubtypeTrade) []WSSubtypeTrade {
+	if levels == nil {
+		return nil
+	}
+	return append(make([]WSSubtypeTrade, 0, len(levels)), levels...)
+}
@@ -0,0 +1,109 @@
+package hitbtc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
+	"github.com/stretchr/testify/require"
+)
+
+// orderbookUpdateRequest returns an updateOrderbook notification with levels
+// on each side.
+func orderbookUpdateRequest(t testing.TB, levels int) *jsonrpc2.Request {
+	update := WSNotificationOrderbookUpdate{Symbol: "ETHBTC", Sequence: 1}
+	for i := 0; i < levels; i++ {
+		update.Ask = append(update.Ask, WSSubtypeTrade{Price: fmt.Sprintf("0.05%d", i), Size: "1"})
+		update.Bid = append(update.Bid, WSSubtypeTrade{Price: fmt.Sprintf("0.04%d", i), Size: "1"})
+	}
+	raw, err := json.Marshal(update)
+	require.NoError(t, err)
+	msg := json.RawMessage(raw)
+	return &jsonrpc2.Request{Method: "updateOrderbook", Params: &msg, Notif: true}
+}
+
+// drainOrderbookUpdates reads the order book updates of the subscription
+// until it is closed.
+func drainOrderbookUpdates(f *Subscription) {
+	go func() {
+		for range f.orderbookUpdates {
+		}
+	}()
+}
+
+func TestWSReusedBuffers(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedOrderbook, "ETHBTC", WithReusedBuffers())
+	defer h.closeAll()
+
+	go func() {
+		notify(t, h, "updateOrderbook", WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "1", Size: "1"}}, Symbol: "ETHBTC", Sequence: 1})
+		notify(t, h, "updateOrderbook", WSNotificationOrderbookUpdate{Bid: []WSSubtypeTrade{{Price: "2", Size: "2"}}, Symbol: "ETHBTC", Sequence: 2})
+		notify(t, h, "updateOrderbook", json.RawMessage(`{"symbol":"ETHBTC","sequence":3,"bid":[{"price":"3","size":"3"}]}`))
+	}()
+
+	first := <-f.orderbookUpdates
+	require.Equal(t, []WSSubtypeTrade{{Price: "1", Size: "1"}}, first.Ask)
+	second := <-f.orderbookUpdates
+	require.Empty(t, second.Ask)
+	require.Equal(t, []WSSubtypeTrade{{Price: "2", Size: "2"}}, second.Bid)
+	third := <-f.orderbookUpdates
+	require.Empty(t, third.Ask)
+	require.Equal(t, int64(3), third.Sequence)
+	require.Equal(t, []WSSubtypeTrade{{Price: "3", Size: "3"}}, third.Bid)
+	require.Equal(t, []WSSubtypeTrade{{Price: "2", Size: "2"}}, second.Bid, "the update held while decoding the next one was overwritten")
+}
+
+func TestOrderbookNotificationClone(t *testing.T) {
+	update := WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "1", Size: "1"}}, Symbol: "ETHBTC", Sequence: 1}
+	clone := update.Clone()
+	update.Ask[0].Size = "0"
+	require.Equal(t, WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "1", Size: "1"}}, Symbol: "ETHBTC", Sequence: 1}, clone)
+	require.Nil(t, clone.Bid)
+
+	snapshot := WSNotificationOrderbookSnapshot{Bid: []WSSubtypeTrade{{Price: "2", Size: "2"}}, Ask: []WSSubtypeTrade{}}
+	cloned := snapshot.Clone()
+	snapshot.Bid[0].Price = "3"
+	require.Equal(t, []WSSubtypeTrade{{Price: "2", Size: "2"}}, cloned.Bid)
+	require.NotNil(t, cloned.Ask)
+}
+
+func TestWSReusedBuffersAllocations(t *testing.T) {
+	req := orderbookUpdateRequest(t, 20)
+	allocs := func(opts ...SubOption) float64 {
+		h := newResponseChannels()
+		drainOrderbookUpdates(h.subscribe(FeedOrderbook, "ETHBTC", opts...))
+		defer h.closeAll()
+		return testing.AllocsPerRun(100, func() {
+			h.Handle(context.Background(), nil, req)
+		})
+	}
+
+	decoded, reused := allocs(), allocs(WithReusedBuffers())
+	t.Logf("allocations per update: %.0f decoded, %.0f reused", decoded, reused)
+	require.Less(t, reused, decoded)
+}
+
+func BenchmarkWSOrderbookUpdate(b *testing.B) {
+	req := orderbookUpdateRequest(b, 20)
+	for _, bench := range []struct {
+		name string
+		opts []SubOption
+	}{
+		{"decoded", nil},
+		{"reused", []SubOption{WithReusedBuffers()}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			h := newResponseChannels()
+			drainOrderbookUpdates(h.subscribe(FeedOrderbook, "ETHBTC", bench.opts...))
+			defer h.closeAll()
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.Handle(context.Background(), nil, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package hitbtc
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/shopspring/decimal"
+)
+
+// CandleBar is a completed OHLCV bar of a market, with exact decimals.
+type CandleBar struct {
+	Symbol    string
+	Period    string
+	Timestamp time.Time // start of the bar
+
+	Open   decimal.Decimal
+	High   decimal.Decimal
+	Low    decimal.Decimal
+	Close  decimal.Decimal
+	Volume decimal.Decimal // traded during the bar, in base currency, not over 24 hours
+	// VolumeQuote is traded during the bar, in quote currency.
+	VolumeQuote decimal.Decimal
+}
+
+// Bar converts the candle of the given market and period to a CandleBar.
+func (c WSCandles) Bar(symbol, period string) (CandleBar, error) {
+	bar := CandleBar{Symbol: symbol, Period: period, Timestamp: c.Timestamp.Time}
+	fields := []struct {
+		name  string
+		value string
+		dst   *decimal.Decimal
+	}{
+		{"open", c.Open, &bar.Open},
+		{"max", c.Max, &bar.High},
+		{"min", c.Min, &bar.Low},
+		{"close", c.Close, &bar.Close},
+		{"volume", c.Volume, &bar.Volume},
+		{"volumeQuote", c.VolumeQuote, &bar.VolumeQuote},
+	}
+	for _, field := range fields {
+		d, err := parseDecimal(field.name, field.value)
+		if err != nil {
+			return CandleBar{}, err
+		}
+		*field.dst = d
+	}
+	return bar, nil
+}
+
+// SubscribeCandleBars subscribes to the candles of the market for the
+// timeframe and returns the bars as they complete, see WithCandleBars.
+//
+// A bar is delivered once the first update of the next period is received.
+// Use UnsubscribeCandles to unsubscribe, which closes the channel.
+func (c *WSClient) SubscribeCandleBars(symbol string, timeframe string, opts ...SubOption) (<-chan CandleBar, error) {
+	s, err := c.subscribe(FeedCandles, symbol, append([]SubOption{WithPeriod(timeframe), WithCandleBars()}, opts...))
+	if err != nil {
+		return nil, annotate(err, "Hitbtc SubscribeCandleBars")
+	}
+	return s.candleBars, nil
+}
+
+// deliverBar delivers a closed candle to the bars of the subscription. A
+// candle that does not convert is reported as a *SymbolError instead.
+func (h *responseChannels) deliverBar(f *Subscription, msg WSNotificationCandlesUpdate) {
+	period := msg.Period
+	if period == "" {
+		period = f.opts.period
+	}
+	bar, err := msg.Data.Bar(msg.Symbol, period)
+	if err != nil {
+		err = &SymbolError{Symbol: msg.Symbol, FeedKind: FeedCandles, Err: err}
+		f.report(err)
+		h.sendError(err)
+		return
+	}
+	select {
+	case f.candleBars <- bar:
+	case <-f.done:
+	}
+}
+
+// TradeBarAggregator aggregates the trades of a market into bars of a fixed
+// interval, aligned on the Unix epoch, for the timeframes without candles.
+//
+// No bar is produced for the intervals without trades.
+type TradeBarAggregator struct {
+	symbol   string
+	interval time.Duration
+
+	current *CandleBar
+	flushed time.Time // end of the last bar flushed
+	lastID  int       // of the last trade aggregated
+}
+
+// NewTradeBarAggregator returns an aggregator into bars of the interval, which
+// must be positive.
+func NewTradeBarAggregator(symbol string, interval time.Duration) *TradeBarAggregator {
+	return &TradeBarAggregator{symbol: symbol, interval: interval}
+}
+
+// Push adds the trade to its bar. It returns the previous bar when the trade
+// starts a new one, false otherwise.
+//
+// The trades must be pushed in order: a trade older than the current bar, of a
+// flushed bar, or already aggregated, is ignored.
+func (a *TradeBarAggregator) Push(trade WSTrades) (CandleBar, bool, error) {
+	price, err := trade.PriceDecimal()
+	if err != nil {
+		return CandleBar{}, false, err
+	}
+	quantity, err := trade.QuantityDecimal()
+	if err != nil {
+		return CandleBar{}, false, err
+	}
+
+	start := a.start(trade.Timestamp.Time)
+	current := a.current
+	switch {
+	case start.Before(a.flushed) || current != nil && start.Before(current.Timestamp) || trade.ID != 0 && trade.ID <= a.lastID:
+		return CandleBar{}, false, nil
+	case current != nil && start.Equal(current.Timestamp):
+		if price.GreaterThan(current.High) {
+			current.High = price
+		}
+		if price.LessThan(current.Low) {
+			current.Low = price
+		}
+		current.Close = price
+		current.Volume = current.Volume.Add(quantity)
+		current.VolumeQuote = current.VolumeQuote.Add(quantity.Mul(price))
+		a.lastID = trade.ID
+		return CandleBar{}, false, nil
+	}
+
+	a.current = &CandleBar{
+		Symbol:      a.symbol,
+		Period:      a.interval.String(),
+		Timestamp:   start,
+		Open:        price,
+		High:        price,
+		Low:         price,
+		Close:       price,
+		Volume:      quantity,
+		VolumeQuote: quantity.Mul(price),
+	}
+	a.lastID = trade.ID
+	if current == nil {
+		return CandleBar{}, false, nil
+	}
+	return *current, true, nil
+}
+
+// Flush returns the current bar if it ended by now, false otherwise. The
+// trades of the bar pushed afterwards are ignored.
+func (a *TradeBarAggregator) Flush(now time.Time) (CandleBar, bool) {
+	end, ok := a.end()
+	if !ok || now.Before(end) {
+		return CandleBar{}, false
+	}
+	bar := *a.current
+	a.current = nil
+	a.flushed = end
+	return bar, true
+}
+
+// end returns the end of the current bar, false if there is none.
+func (a *TradeBarAggregator) end() (time.Time, bool) {
+	if a.current == nil {
+		return time.Time{}, false
+	}
+	return a.current.Timestamp.Add(a.interval), true
+}
+
+// start returns the start of the bar of the time.
+func (a *TradeBarAggregator) start(t time.Time) time.Time {
+	ns := t.UnixNano()
+	offset := ns % int64(a.interval)
+	if offset < 0 {
+		offset += int64(a.interval)
+	}
+	return time.Unix(0, ns-offset).UTC()
+}
+
+// SubscribeTradeBars subscribes to the trades of the market and returns them
+// aggregated into bars of the interval, e.g. 7 seconds, see
+// TradeBarAggregator. A bar is delivered at its end, by the local clock, or
+// once the first trade of a later bar is received if sooner: the trades of the
+// bar received afterwards are ignored. No bar is delivered for the intervals
+// without trades. The trades snapshots are not aggregated.
+//
+// The trades subscription must not be read elsewhere. Use UnsubscribeTrades to
+// unsubscribe, which closes the channel and drops the bar in progress.
+func (c *WSClient) SubscribeTradeBars(symbol string, interval time.Duration) (<-chan CandleBar, error) {
+	if interval <= 0 {
+		return nil, errors.NotValidf("Hitbtc SubscribeTradeBars interval %s", interval)
+	}
+	s, err := c.subscribe(FeedTrades, symbol, nil)
+	if err != nil {
+		return nil, annotate(err, "Hitbtc SubscribeTradeBars")
+	}
+
+	bars := make(chan CandleBar)
+	go aggregateTrades(c.updates, s, NewTradeBarAggregator(c.updates.symbolName(s.symbol), interval), bars)
+	return bars, nil
+}
+
+// aggregateTrades delivers the bars of the trades of the subscription until
+// it is unsubscribed, each bar being flushed at its end.
+func aggregateTrades(h *responseChannels, s *Subscription, aggregator *TradeBarAggregator, bars chan<- CandleBar) {
+	defer close(bars)
+
+	var timer *time.Timer
+	var flush <-chan time.Time
+	var deadline time.Time // end of the bar the timer is set for
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	snapshots := s.tradesSnapshots
+	for {
+		var bar CandleBar
+		var closed bool
+		select {
+		case _, ok := <-snapshots:
+			if !ok {
+				snapshots = nil
+			}
+			continue
+		case <-flush:
+			flush = nil
+			bar, closed = aggregator.Flush(deadline)
+		case update, ok := <-s.tradesUpdates:
+			if !ok {
+				return
+			}
+			var err error
+			bar, closed, err = aggregator.Push(update.Data)
+			if err != nil {
+				reportAggregation(h, s, err)
+				continue
+			}
+		}
+
+		if end, ok := aggregator.end(); ok && !end.Equal(deadline) {
+			if timer != nil {
+				timer.Stop()
+			}
+			deadline = end
+			timer = time.NewTimer(time.Until(end))
+			flush = timer.C
+		}
+		if !closed {
+			continue
+		}
+		select {
+		case bars <- bar:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// reportAggregation reports a trade that could not be aggregated to the
+// subscription, registering a delivery so that it is not closed meanwhile. It
+// is dropped once unsubscribed.
+func reportAggregation(h *responseChannels, s *Subscription, err error) {
+	f := h.acquire(FeedTrades, s.symbol)
+	if f == nil {
+		return
+	}
+	defer f.inflight.Done()
+	if f == s {
+		f.report(&SymbolError{Symbol: s.symbol, FeedKind: FeedTrades, Err: err})
+	}
+}
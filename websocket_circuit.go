@@ -0,0 +1,114 @@
+package hitbtc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// CircuitState is the state of the circuit breaker of the order placements,
+// see WithOrderCircuitBreaker.
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // the placements are allowed
+	CircuitOpen                         // the placements fail fast with ErrCircuitOpen
+	CircuitHalfOpen                     // the cool-down elapsed, the next placement probes hitbtc
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// WithOrderCircuitBreaker opens the circuit of PlaceOrder after the given
+// number of consecutive failed placements, e.g. on insufficient funds or rate
+// limiting, so that the placements fail fast with ErrCircuitOpen during the
+// cool-down instead of hammering hitbtc. Once the cool-down elapsed, a single
+// placement is attempted: its success closes the circuit and its failure opens
+// it again. The placements cancelled by their context are not counted.
+//
+// It is disabled by default.
+func WithOrderCircuitBreaker(failures int, coolDown time.Duration) Option {
+	return func(o *wsOptions) {
+		if failures > 0 && coolDown > 0 {
+			o.circuitFailures = failures
+			o.circuitCoolDown = coolDown
+		}
+	}
+}
+
+// circuitBreaker counts the consecutive failures of the order placements.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	failures int       // consecutive
+	openedAt time.Time // zero while closed
+	probing  bool      // a placement is attempted while half-open
+}
+
+// CircuitState returns the state of the circuit breaker of the order
+// placements, always CircuitClosed unless WithOrderCircuitBreaker is set.
+func (c *WSClient) CircuitState() CircuitState {
+	c.circuit.mu.Lock()
+	defer c.circuit.mu.Unlock()
+	return c.circuit.state(c.options.circuitCoolDown, time.Now())
+}
+
+// state returns the state of the circuit. It must be called with mu held.
+func (b *circuitBreaker) state(coolDown time.Duration, now time.Time) CircuitState {
+	switch {
+	case b.openedAt.IsZero():
+		return CircuitClosed
+	case b.probing || now.Sub(b.openedAt) < coolDown:
+		return CircuitOpen
+	}
+	return CircuitHalfOpen
+}
+
+// allow reports ErrCircuitOpen when a placement must fail fast, and starts
+// the probe of a half-open circuit otherwise.
+func (b *circuitBreaker) allow(o wsOptions, now time.Time) error {
+	if o.circuitFailures == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state(o.circuitCoolDown, now) {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		b.probing = true
+	}
+	return nil
+}
+
+// record records the result of an allowed placement.
+func (b *circuitBreaker) record(o wsOptions, err error, now time.Time) {
+	if o.circuitFailures == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probing := b.probing
+	b.probing = false
+	switch {
+	case err == nil:
+		b.failures = 0
+		b.openedAt = time.Time{}
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrOrderOutcomeUnknown):
+	default:
+		b.failures++
+		if probing || b.failures >= o.circuitFailures {
+			b.openedAt = now
+		}
+	}
+}
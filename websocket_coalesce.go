@@ -0,0 +1,148 @@
+package hitbtc
+
+import "sync"
+
+// orderbookCoalescer delivers order book updates to a consumer, merging the
+// updates received while the consumer is busy into a single pending one.
+type orderbookCoalescer struct {
+	out chan<- WSNotificationOrderbookUpdate
+
+	mu      sync.Mutex
+	pending *WSNotificationOrderbookUpdate
+
+	notify chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newOrderbookCoalescer(out chan<- WSNotificationOrderbookUpdate) *orderbookCoalescer {
+	c := &orderbookCoalescer{
+		out:    out,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// merge merges the update into the pending one, without waking up the
+// delivery, and returns the sequence of the pending update merged away, if any.
+func (c *orderbookCoalescer) merge(update WSNotificationOrderbookUpdate) (dropped int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = &update
+	} else {
+		dropped, ok = c.pending.Sequence, true
+		mergeOrderbookUpdate(c.pending, update)
+	}
+	return dropped, ok
+}
+
+// wake wakes up the delivery of the pending update, if any.
+func (c *orderbookCoalescer) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// reset drops the pending update, used when a fresh snapshot supersedes it.
+func (c *orderbookCoalescer) reset() {
+	c.mu.Lock()
+	c.pending = nil
+	c.mu.Unlock()
+}
+
+// stop terminates the delivery and waits for it to return.
+func (c *orderbookCoalescer) stop() {
+	close(c.done)
+	c.wg.Wait()
+}
+
+func (c *orderbookCoalescer) run() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.notify:
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		update := c.pending
+		c.pending = nil
+		c.mu.Unlock()
+		if update == nil {
+			continue
+		}
+
+		select {
+		case c.out <- *update:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// mergeOrderbookUpdate applies src on top of dst, the levels of src replacing the
+// levels of dst with the same price.
+func mergeOrderbookUpdate(dst *WSNotificationOrderbookUpdate, src WSNotificationOrderbookUpdate) {
+	dst.Raw = nil // no longer the notification received
+	dst.Ask = mergeLevels(dst.Ask, src.Ask)
+	dst.Bid = mergeLevels(dst.Bid, src.Bid)
+	if src.Sequence > dst.Sequence {
+		dst.Sequence = src.Sequence
+	}
+}
+
+func mergeLevels(dst, src []WSSubtypeTrade) []WSSubtypeTrade {
+	merged := make([]WSSubtypeTrade, 0, len(dst)+len(src))
+	index := make(map[string]int, len(dst)+len(src))
+	for _, levels := range [][]WSSubtypeTrade{dst, src} {
+		for _, level := range levels {
+			if i, ok := index[level.Price]; ok {
+				merged[i] = level
+				continue
+			}
+			index[level.Price] = len(merged)
+			merged = append(merged, level)
+		}
+	}
+	return merged
+}
+
+// candleCloser tracks the in-progress candle of a subscription to detect when
+// it is closed.
+type candleCloser struct {
+	mu      sync.Mutex
+	current *WSCandles
+}
+
+// reset sets the in-progress candle, e.g. the last candle of a snapshot.
+func (c *candleCloser) reset(candle WSCandles) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = &candle
+}
+
+// push records the updated candle and returns the previous candle when the
+// update starts a new period, false otherwise.
+func (c *candleCloser) push(candle WSCandles) (WSCandles, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.current
+	switch {
+	case current == nil || candle.Timestamp.Equal(current.Timestamp.Time):
+		c.current = &candle
+		return WSCandles{}, false
+	case candle.Timestamp.Before(current.Timestamp.Time):
+		// late update of an already closed candle.
+		return WSCandles{}, false
+	}
+	c.current = &candle
+	return *current, true
+}
@@ -0,0 +1,36 @@
+package hitbtc
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// RemoteAddr returns the address of the hitbtc endpoint the current connection
+// is established with, e.g. for audit logging, nil when the client is connected
+// to a ReplaySource.
+func (c *WSClient) RemoteAddr() net.Addr {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	if c.stream == nil {
+		return nil
+	}
+	return c.stream.ws.RemoteAddr()
+}
+
+// TLSState returns the state of the TLS session of the current connection,
+// such as the negotiated version and cipher suite, nil when the connection is
+// not encrypted, e.g. with a ws:// url set with WithURL, or the client is
+// connected to a ReplaySource.
+func (c *WSClient) TLSState() *tls.ConnectionState {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	if c.stream == nil {
+		return nil
+	}
+	tlsConn, ok := c.stream.ws.UnderlyingConn().(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	return &state
+}
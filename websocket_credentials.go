@@ -0,0 +1,74 @@
+package hitbtc
+
+import (
+	"context"
+
+	"github.com/juju/errors"
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
+)
+
+// Credential is an API key of the account, see WithCredentials.
+type Credential struct {
+	APIKey    string
+	SecretKey string
+}
+
+// session returns the credentials the session is logged in with, nil if it is not.
+func (c *WSClient) session() *wsCredentials {
+	c.credMu.Lock()
+	defer c.credMu.Unlock()
+	return c.credentials
+}
+
+func (c *WSClient) setSession(credentials *wsCredentials) {
+	c.credMu.Lock()
+	defer c.credMu.Unlock()
+	c.credentials = credentials
+}
+
+// loginFirstKey logs the session in with the first of the keys of WithCredentials.
+func (c *WSClient) loginFirstKey() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.defaultTimeout)
+	defer cancel()
+
+	key := c.options.credentials[0]
+	credentials := wsCredentials{apiKey: key.APIKey, secretKey: key.SecretKey}
+	if err := c.login(ctx, credentials); err != nil {
+		return err
+	}
+	c.setSession(&credentials)
+	return nil
+}
+
+// rotate logs the session in with the next of the keys of WithCredentials,
+// unless the session is no longer logged in with the used credentials: the
+// calls failing together with the same key rotate once.
+//
+// The key is skipped even when the login fails, so that the next rotation
+// tries the following one.
+func (c *WSClient) rotate(ctx context.Context, used *wsCredentials) error {
+	c.rotateMu.Lock()
+	defer c.rotateMu.Unlock()
+	if c.session() != used {
+		return nil
+	}
+
+	keys := c.options.credentials
+	c.keyIndex = (c.keyIndex + 1) % len(keys)
+	next := wsCredentials{apiKey: keys[c.keyIndex].APIKey, secretKey: keys[c.keyIndex].SecretKey}
+	if err := c.login(ctx, next); err != nil {
+		return errors.Annotatef(err, "key %d", c.keyIndex)
+	}
+	c.setSession(&next)
+	return nil
+}
+
+// isKeyExhausted reports whether err is one of the errors rotating the key of
+// WithCredentials: rate limited or action forbidden.
+func isKeyExhausted(err error) bool {
+	var rpcErr *jsonrpc2.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	return rpcErr.Code == 429 || rpcErr.Code == CodeActionForbidden
+}
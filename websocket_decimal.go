@@ -0,0 +1,128 @@
+package hitbtc
+
+import (
+	"github.com/juju/errors"
+	"github.com/shopspring/decimal"
+)
+
+// parseDecimal parses a decimal field of a websocket message.
+func parseDecimal(field, value string) (decimal.Decimal, error) {
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero, errors.Annotatef(err, "%s %q", field, value)
+	}
+	return d, nil
+}
+
+// VolumeDecimal returns the 24 hours volume in base currency as an exact decimal.
+func (t WSNotificationTickerResponse) VolumeDecimal() (decimal.Decimal, error) {
+	return parseDecimal("volume", t.Volume)
+}
+
+// VolumeQuoteDecimal returns the 24 hours volume in quote currency as an exact decimal.
+func (t WSNotificationTickerResponse) VolumeQuoteDecimal() (decimal.Decimal, error) {
+	return parseDecimal("volumeQuote", t.VolumeQuote)
+}
+
+// VolumePerBar returns the volume traded during the period of the candle, in
+// base currency, as an exact decimal. Unlike the ticker volume it does not
+// cover the last 24 hours: summing the volumes of the candles of a day gives
+// the volume of the day.
+func (c WSCandles) VolumePerBar() (decimal.Decimal, error) {
+	return parseDecimal("volume", c.Volume)
+}
+
+// VolumeQuotePerBar returns the volume traded during the period of the candle,
+// in quote currency, as an exact decimal.
+func (c WSCandles) VolumeQuotePerBar() (decimal.Decimal, error) {
+	return parseDecimal("volumeQuote", c.VolumeQuote)
+}
+
+// VolumeDecimal returns the volume of the candle in base currency as an exact decimal.
+//
+// Deprecated: use VolumePerBar.
+func (c WSCandles) VolumeDecimal() (decimal.Decimal, error) {
+	return c.VolumePerBar()
+}
+
+// VolumeQuoteDecimal returns the volume of the candle in quote currency as an exact decimal.
+//
+// Deprecated: use VolumeQuotePerBar.
+func (c WSCandles) VolumeQuoteDecimal() (decimal.Decimal, error) {
+	return c.VolumeQuotePerBar()
+}
+
+// PayoutFeeDecimal returns the fee of a withdrawal of the currency as an exact
+// decimal. The api v2 has a single flat fee per currency, in the currency,
+// neither tiered nor proportional to the amount: the net amount of a
+// withdrawal is the amount minus the fee.
+func (c WSGetCurrencyResponse) PayoutFeeDecimal() (decimal.Decimal, error) {
+	return parseDecimal("payoutFee", c.PayoutFee)
+}
+
+// TickSizeDecimal returns the price increment of the market as an exact decimal.
+func (s WSGetSymbolResponse) TickSizeDecimal() (decimal.Decimal, error) {
+	return parseDecimal("tickSize", s.TickSize)
+}
+
+// QuantityIncrementDecimal returns the quantity increment of the market as an
+// exact decimal.
+func (s WSGetSymbolResponse) QuantityIncrementDecimal() (decimal.Decimal, error) {
+	return parseDecimal("quantityIncrement", s.QuantityIncrement)
+}
+
+// RoundPrice snaps the price of an order of the side to a multiple of the tick
+// size of the market, never to a worse price: down for a buy, up for a sell.
+func (s WSGetSymbolResponse) RoundPrice(price decimal.Decimal, side TradeSide) (decimal.Decimal, error) {
+	tick, err := s.TickSizeDecimal()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return snap(price, tick, side == TradeSideSell), nil
+}
+
+// RoundQuantity snaps the quantity of an order down to a multiple of the
+// quantity increment of the market. A quantity below the increment is not
+// valid.
+func (s WSGetSymbolResponse) RoundQuantity(quantity decimal.Decimal) (decimal.Decimal, error) {
+	increment, err := s.QuantityIncrementDecimal()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	rounded := snap(quantity, increment, false)
+	if !rounded.IsPositive() {
+		return decimal.Zero, errors.NotValidf("quantity %s below the increment %s", quantity, increment)
+	}
+	return rounded, nil
+}
+
+// snap rounds the value to a multiple of step, down or up.
+func snap(value, step decimal.Decimal, up bool) decimal.Decimal {
+	if !step.IsPositive() {
+		return value
+	}
+	rest := value.Mod(step)
+	if rest.IsZero() {
+		return value
+	}
+	if rest.IsNegative() {
+		rest = rest.Add(step)
+	}
+	value = value.Sub(rest)
+	if up {
+		value = value.Add(step)
+	}
+	return value
+}
+
+// TakeRate returns the fee rate of the orders taking liquidity, e.g. 0.001
+// for 0.1%.
+func (s WSGetSymbolResponse) TakeRate() (decimal.Decimal, error) {
+	return parseDecimal("takeLiquidityRate", s.TakeLiquidityRate)
+}
+
+// ProvideRate returns the fee rate of the orders providing liquidity, negative
+// for a rebate.
+func (s WSGetSymbolResponse) ProvideRate() (decimal.Decimal, error) {
+	return parseDecimal("provideLiquidityRate", s.ProvideLiquidityRate)
+}
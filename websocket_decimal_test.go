@@ -0,0 +1,100 @@
+package hitbtc
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecimalVolumeSum(t *testing.T) {
+	sum := decimal.Zero
+	for i := 0; i < 10; i++ {
+		volume, err := WSCandles{Volume: "0.1"}.VolumeDecimal()
+		require.NoError(t, err)
+		sum = sum.Add(volume)
+	}
+	require.True(t, sum.Equal(decimal.NewFromInt(1)), sum.String())
+
+	volume, err := WSCandles{Volume: "0.1", VolumeQuote: "0.005"}.VolumePerBar()
+	require.NoError(t, err)
+	require.Equal(t, "0.1", volume.String())
+	volume, err = WSCandles{Volume: "0.1", VolumeQuote: "0.005"}.VolumeQuotePerBar()
+	require.NoError(t, err)
+	require.Equal(t, "0.005", volume.String())
+
+	_, err = WSNotificationTickerResponse{VolumeQuote: "1,5"}.VolumeQuoteDecimal()
+	require.Error(t, err)
+}
+
+func TestDecimalTradesVolumeBySide(t *testing.T) {
+	trades := []WSTrades{
+		{Price: "0.05", Quantity: "1.1", Side: "buy"},
+		{Price: "0.05", Quantity: "0.2", Side: "sell"},
+		{Price: "0.06", Quantity: "0.9", Side: "buy"},
+	}
+	volumes := make(map[TradeSide]decimal.Decimal)
+	for _, trade := range trades {
+		quantity, err := trade.QuantityDecimal()
+		require.NoError(t, err)
+		volumes[trade.TakerSide()] = volumes[trade.TakerSide()].Add(quantity)
+	}
+	require.Equal(t, "2", volumes[TradeSideBuy].String())
+	require.Equal(t, "0.2", volumes[TradeSideSell].String())
+	require.Equal(t, TradeSideUnknown, WSTrades{Side: "BUY"}.TakerSide())
+	require.Equal(t, "sell", TradeSideSell.String())
+}
+
+func TestDecimalSymbol(t *testing.T) {
+	symbol := WSGetSymbolResponse{TickSize: "0.000001", QuantityIncrement: "0.001", TakeLiquidityRate: "0.001", ProvideLiquidityRate: "-0.0001"}
+	tick, err := symbol.TickSizeDecimal()
+	require.NoError(t, err)
+	require.Equal(t, "0.000001", tick.String())
+	increment, err := symbol.QuantityIncrementDecimal()
+	require.NoError(t, err)
+	require.Equal(t, "0.001", increment.String())
+	take, err := symbol.TakeRate()
+	require.NoError(t, err)
+	require.Equal(t, "0.001", take.String())
+	provide, err := symbol.ProvideRate()
+	require.NoError(t, err)
+	require.True(t, provide.IsNegative())
+
+	_, err = WSGetSymbolResponse{}.TakeRate()
+	require.Error(t, err)
+}
+
+func TestDecimalPayoutFee(t *testing.T) {
+	fee, err := WSGetCurrencyResponse{ID: "BTC", PayoutFee: "0.000900000000"}.PayoutFeeDecimal()
+	require.NoError(t, err)
+	require.Equal(t, "0.0009", fee.String())
+	require.Equal(t, "0.0991", decimal.RequireFromString("0.1").Sub(fee).String())
+
+	_, err = WSGetCurrencyResponse{ID: "EUR"}.PayoutFeeDecimal()
+	require.Error(t, err)
+}
+
+func TestDecimalRoundToMarket(t *testing.T) {
+	symbol := WSGetSymbolResponse{TickSize: "0.005", QuantityIncrement: "0.1"}
+	for _, c := range []struct {
+		price string
+		side  TradeSide
+		want  string
+	}{
+		{"1.012", TradeSideBuy, "1.01"},
+		{"1.012", TradeSideSell, "1.015"},
+		{"1.015", TradeSideSell, "1.015"},
+	} {
+		price, err := symbol.RoundPrice(decimal.RequireFromString(c.price), c.side)
+		require.NoError(t, err)
+		require.Equal(t, c.want, price.String(), "%s %s", c.side, c.price)
+	}
+
+	quantity, err := symbol.RoundQuantity(decimal.RequireFromString("2.59"))
+	require.NoError(t, err)
+	require.Equal(t, "2.5", quantity.String())
+	_, err = symbol.RoundQuantity(decimal.RequireFromString("0.09"))
+	require.Error(t, err)
+	_, err = WSGetSymbolResponse{}.RoundQuantity(decimal.NewFromInt(1))
+	require.Error(t, err)
+}
@@ -0,0 +1,193 @@
+package hitbtc
+
+import "strconv"
+
+// decodeOrderbookUpdate decodes an updateOrderbook notification without
+// reflection. It only accepts the plain form sent by hitbtc, the exact keys
+// once each and strings without escape or non ASCII byte, and reports false for any
+// other input, to be decoded by encoding/json instead. An accepted input is
+// decoded as encoding/json does.
+func decodeOrderbookUpdate(data []byte, msg *WSNotificationOrderbookUpdate) bool {
+	d := fastDecoder{data: data}
+	var decoded WSNotificationOrderbookUpdate
+	var seen [4]bool // ask, bid, symbol and sequence
+	if !d.consume('{') {
+		return false
+	}
+	if d.consume('}') {
+		return d.end(msg, decoded)
+	}
+	for {
+		key, ok := d.string()
+		if !ok || !d.consume(':') {
+			return false
+		}
+		var field int
+		switch string(key) {
+		case "ask":
+			field = 0
+			decoded.Ask, ok = d.levels()
+		case "bid":
+			field = 1
+			decoded.Bid, ok = d.levels()
+		case "symbol":
+			field = 2
+			var symbol []byte
+			symbol, ok = d.string()
+			decoded.Symbol = string(symbol)
+		case "sequence":
+			field = 3
+			decoded.Sequence, ok = d.int64()
+		default:
+			return false
+		}
+		// encoding/json decodes a repeated array into the previous elements.
+		if !ok || seen[field] {
+			return false
+		}
+		seen[field] = true
+		if d.consume('}') {
+			return d.end(msg, decoded)
+		}
+		if !d.consume(',') {
+			return false
+		}
+	}
+}
+
+// fastDecoder scans the JSON documents accepted by decodeOrderbookUpdate.
+type fastDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *fastDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// consume skips the byte c, reporting whether it is next.
+func (d *fastDecoder) consume(c byte) bool {
+	d.skipSpace()
+	if d.pos < len(d.data) && d.data[d.pos] == c {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// end stores the decoded message if nothing but spaces is left.
+func (d *fastDecoder) end(msg *WSNotificationOrderbookUpdate, decoded WSNotificationOrderbookUpdate) bool {
+	d.skipSpace()
+	if d.pos != len(d.data) {
+		return false
+	}
+	*msg = decoded
+	return true
+}
+
+// string returns the content of a string without escape or non ASCII byte.
+func (d *fastDecoder) string() ([]byte, bool) {
+	if !d.consume('"') {
+		return nil, false
+	}
+	start := d.pos
+	for ; d.pos < len(d.data); d.pos++ {
+		switch c := d.data[d.pos]; {
+		case c == '"':
+			d.pos++
+			return d.data[start : d.pos-1], true
+		case c == '\\' || c < 0x20 || c >= 0x80:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// int64 returns an integer fitting an int64, without fraction nor exponent.
+func (d *fastDecoder) int64() (int64, bool) {
+	d.skipSpace()
+	start := d.pos
+	if d.pos < len(d.data) && d.data[d.pos] == '-' {
+		d.pos++
+	}
+	digits := d.pos
+	for d.pos < len(d.data) && d.data[d.pos] >= '0' && d.data[d.pos] <= '9' {
+		d.pos++
+	}
+	if d.pos == digits || (d.data[digits] == '0' && d.pos-digits > 1) {
+		return 0, false
+	}
+	if d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case '.', 'e', 'E':
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(string(d.data[start:d.pos]), 10, 64)
+	return n, err == nil
+}
+
+// levels returns an array of price levels, empty but not nil for [].
+func (d *fastDecoder) levels() ([]WSSubtypeTrade, bool) {
+	if !d.consume('[') {
+		return nil, false
+	}
+	levels := []WSSubtypeTrade{}
+	if d.consume(']') {
+		return levels, true
+	}
+	for {
+		level, ok := d.level()
+		if !ok {
+			return nil, false
+		}
+		levels = append(levels, level)
+		if d.consume(']') {
+			return levels, true
+		}
+		if !d.consume(',') {
+			return nil, false
+		}
+	}
+}
+
+func (d *fastDecoder) level() (WSSubtypeTrade, bool) {
+	var level WSSubtypeTrade
+	if !d.consume('{') {
+		return level, false
+	}
+	if d.consume('}') {
+		return level, true
+	}
+	for {
+		key, ok := d.string()
+		if !ok || !d.consume(':') {
+			return level, false
+		}
+		value, ok := d.string()
+		if !ok {
+			return level, false
+		}
+		switch string(key) {
+		case "price":
+			level.Price = string(value)
+		case "size":
+			level.Size = string(value)
+		default:
+			return level, false
+		}
+		if d.consume('}') {
+			return level, true
+		}
+		if !d.consume(',') {
+			return level, false
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package hitbtc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeOrderbookUpdate(t *testing.T) {
+	for _, input := range []string{
+		`{"ask":[{"price":"0.054588","size":"0.245"},{"price":"0.054590","size":"0.000"}],"bid":[],"symbol":"ETHBTC","sequence":8073830}`,
+		` { "symbol" : "ETHBTC" , "sequence" : -0 , "bid" : [ { "size" : "1" , "price" : "2" } , { } ] } `,
+		`{"ask":[{"price":"1","size":"1"}],"ask":[{"price":"2"}],"sequence":1,"sequence":2}`,
+		`{}`,
+		`{"ask":null}`,
+		`{"Symbol":"ETHBTC"}`,
+		`{"symbol":"ETHBTC"}`,
+		`{"symbol":"ÉTH"}`,
+		`{"sequence":1.0}`,
+		`{"sequence":1e3}`,
+		`{"sequence":01}`,
+		`{"sequence":9223372036854775808}`,
+		`{"sequence":"1"}`,
+		`{"ask":[{"price":"1","size":"1","extra":true}]}`,
+		`{"ask":[{"price":"1",}]}`,
+		`{"ask":[],}`,
+		`{"symbol":"ETHBTC"} x`,
+		`{"symbol":"ETHBTC"`,
+		`[]`,
+		``,
+	} {
+		var fast, std WSNotificationOrderbookUpdate
+		stdErr := json.Unmarshal([]byte(input), &std)
+		if decodeOrderbookUpdate([]byte(input), &fast) {
+			require.NoError(t, stdErr, input)
+			require.Equal(t, std, fast, input)
+		}
+	}
+
+	var msg WSNotificationOrderbookUpdate
+	require.True(t, decodeOrderbookUpdate([]byte(`{"ask":[{"price":"1","size":"2"}],"bid":[],"symbol":"ETHBTC","sequence":3}`), &msg))
+	require.Equal(t, WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "1", Size: "2"}}, Bid: []WSSubtypeTrade{}, Symbol: "ETHBTC", Sequence: 3}, msg)
+	require.False(t, decodeOrderbookUpdate([]byte(`{"Symbol":"ETHBTC"}`), &msg))
+	require.Equal(t, "ETHBTC", msg.Symbol, "the message is left as is when not decoded")
+}
+
+func TestWSFastDecoding(t *testing.T) {
+	h := newResponseChannels()
+	h.fastDecoding = true
+	f := h.subscribe(FeedOrderbook, "ETHBTC")
+	defer h.closeAll()
+
+	go func() {
+		notify(t, h, "updateOrderbook", json.RawMessage(`{"ask":[{"price":"1","size":"2"}],"symbol":"ETHBTC","sequence":3}`))
+		notify(t, h, "updateOrderbook", json.RawMessage(`{"symbol":"ETHBTC","sequence":4}`))
+		notify(t, h, "updateOrderbook", json.RawMessage(`{"symbol":"ETHBTC","sequence":"5"}`))
+	}()
+	require.Equal(t, WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "1", Size: "2"}}, Symbol: "ETHBTC", Sequence: 3}, <-f.orderbookUpdates)
+	require.Equal(t, int64(4), (<-f.orderbookUpdates).Sequence)
+	require.Error(t, <-h.ErrorFeed)
+}
+
+func BenchmarkDecodeOrderbookUpdate(b *testing.B) {
+	data := *orderbookUpdateRequest(b, 20).Params
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var msg WSNotificationOrderbookUpdate
+			if err := json.Unmarshal(data, &msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var msg WSNotificationOrderbookUpdate
+			if !decodeOrderbookUpdate(data, &msg) {
+				b.Fatal("not decoded")
+			}
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package hitbtc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// recentTerminalReports is the number of terminal reports kept for the orders
+// that completed before WaitForFill was called.
+const recentTerminalReports = 256
+
+// Terminal reports whether the order of the report reached a final state:
+// filled, canceled or expired, or closed meanwhile, see StatusClosed.
+func (r WSReport) Terminal() bool {
+	switch r.Status {
+	case "filled", "canceled", "expired", StatusClosed:
+		return true
+	}
+	return r.ReportType == ReportTypeCanceled || r.ReportType == ReportTypeExpired
+}
+
+// fillWaiters dispatches the terminal reports to the calls of WaitForFill.
+type fillWaiters struct {
+	mu      sync.Mutex
+	waiters map[string][]chan WSReport // by client order id
+	recent  map[string]WSReport        // last terminal reports, by client order id
+	order   []string                   // of the recent reports, oldest first
+}
+
+// report records the report if it is terminal and wakes the calls waiting for
+// its order.
+func (w *fillWaiters) report(r WSReport) {
+	if !r.Terminal() || r.ClientOrderID == "" {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.recent == nil {
+		w.recent = make(map[string]WSReport)
+	}
+	if _, ok := w.recent[r.ClientOrderID]; !ok {
+		w.order = append(w.order, r.ClientOrderID)
+		if len(w.order) > recentTerminalReports {
+			delete(w.recent, w.order[0])
+			w.order = w.order[1:]
+		}
+	}
+	w.recent[r.ClientOrderID] = r
+
+	for _, ch := range w.waiters[r.ClientOrderID] {
+		ch <- r
+	}
+	delete(w.waiters, r.ClientOrderID)
+}
+
+// completed reports whether a terminal report of the order was received.
+func (w *fillWaiters) completed(clientOrderID string) bool {
+	_, ok := w.terminal(clientOrderID)
+	return ok
+}
+
+// terminal returns the terminal report received for the order, if any.
+func (w *fillWaiters) terminal(clientOrderID string) (WSReport, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r, ok := w.recent[clientOrderID]
+	return r, ok
+}
+
+// wait returns the channel receiving the terminal report of the order, at
+// once if it was already received. The returned function must be called once
+// done waiting.
+func (w *fillWaiters) wait(clientOrderID string) (<-chan WSReport, func()) {
+	ch := make(chan WSReport, 1)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if r, ok := w.recent[clientOrderID]; ok {
+		ch <- r
+		return ch, func() {}
+	}
+	if w.waiters == nil {
+		w.waiters = make(map[string][]chan WSReport)
+	}
+	w.waiters[clientOrderID] = append(w.waiters[clientOrderID], ch)
+
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		waiters := w.waiters[clientOrderID]
+		for i := range waiters {
+			if waiters[i] == ch {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(w.waiters, clientOrderID)
+		} else {
+			w.waiters[clientOrderID] = waiters
+		}
+	}
+}
+
+// WaitForFill blocks until the order of the client order id is filled,
+// canceled or expired and returns its last report, see WSReport.Terminal.
+//
+// The reports must be subscribed, see SubscribeReports, and their channel
+// still read. The orders that completed since the subscription are found
+// even when they completed before the call. An order that is neither active
+// nor completed since the subscription is reported as not found.
+//
+// It returns when ctx expires, or when the reports are unsubscribed.
+func (c *WSClient) WaitForFill(ctx context.Context, clientOrderID string) (*WSReport, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	if clientOrderID == "" {
+		return nil, errors.NotValidf("Hitbtc WaitForFill empty clientOrderId")
+	}
+	s := c.updates.lookup(FeedReports, "")
+	if s == nil {
+		return nil, errors.NotFoundf("Hitbtc WaitForFill reports subscription")
+	}
+
+	reports, cancel := c.updates.fills.wait(clientOrderID)
+	defer cancel()
+	select {
+	case r := <-reports:
+		return &r, nil
+	default:
+	}
+
+	// the order may have completed before the subscription.
+	callCtx, cancelCall := context.WithTimeout(ctx, c.options.defaultTimeout)
+	active, err := c.activeOrder(callCtx, clientOrderID)
+	cancelCall()
+	if err != nil {
+		return nil, errors.Annotate(wsAPIError(err), "Hitbtc WaitForFill")
+	}
+	if active == nil {
+		select {
+		case r := <-reports:
+			return &r, nil
+		default:
+			return nil, errors.NotFoundf("Hitbtc WaitForFill order %s", clientOrderID)
+		}
+	}
+
+	select {
+	case r := <-reports:
+		return &r, nil
+	case <-s.done:
+		return nil, errors.New("Hitbtc WaitForFill: reports unsubscribed")
+	case <-c.updates.done:
+		return nil, ErrClientClosed
+	case <-ctx.Done():
+		return nil, errors.Annotate(ctx.Err(), "Hitbtc WaitForFill")
+	}
+}
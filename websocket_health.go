@@ -0,0 +1,43 @@
+package hitbtc
+
+import "time"
+
+// readinessWindow is how long the market data feeds may be silent before the
+// client is not ready anymore, hitbtc pushing the tickers and the order books
+// every few seconds.
+const readinessWindow = time.Minute
+
+// Liveness reports whether the client is alive: it is not closed and holds a
+// connection, which may be down until reconnected. Unlike Readiness, it does
+// not fail during the transient disconnections, so that a liveness probe does
+// not restart a client able to recover.
+func (c *WSClient) Liveness() bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return !c.closed && c.conn != nil
+}
+
+// Readiness reports whether the client serves data: its connection is up, the
+// last reconnection fully restored the session, logged in again if Login was
+// called, and, when a market data feed is subscribed, a notification was
+// received within the last minute. Unlike Ready, it sends no request.
+func (c *WSClient) Readiness() bool {
+	c.connMu.RLock()
+	conn, closed, lost, lastErr := c.conn, c.closed, !c.lostAt.IsZero(), c.lastErr
+	c.connMu.RUnlock()
+	if closed || lost || lastErr != nil || conn == nil {
+		return false
+	}
+	select {
+	case <-conn.DisconnectNotify():
+		return false
+	default:
+	}
+
+	for _, s := range c.updates.subscriptions() {
+		if s.kind != FeedReports && s.kind != FeedBalance {
+			return time.Since(c.updates.state.lastReceived()) < readinessWindow
+		}
+	}
+	return true
+}
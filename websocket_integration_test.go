@@ -0,0 +1,52 @@
+//go:build integration
+
+package hitbtc_test
+
+import (
+	"testing"
+	"time"
+
+	hitbtc "github.com/bitzlato/go-hitbtc"
+	"github.com/stretchr/testify/require"
+)
+
+// The integration tests run against the live hitbtc api, to catch the changes
+// of the protocol that the hermetic tests cannot:
+//
+//	go test -tags integration -run Integration .
+
+const (
+	integrationSymbol  = "ETHBTC"
+	integrationTimeout = 30 * time.Second
+)
+
+func newIntegrationClient(t *testing.T) *hitbtc.WSClient {
+	client, err := hitbtc.NewWSClient(hitbtc.WithDefaultTimeout(integrationTimeout))
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestIntegrationTicker(t *testing.T) {
+	client := newIntegrationClient(t)
+
+	ticker, err := client.SubscribeTicker(integrationSymbol)
+	require.NoError(t, err)
+	select {
+	case msg := <-ticker:
+		require.Equal(t, integrationSymbol, msg.Symbol)
+		require.NotEmpty(t, msg.Last)
+	case <-time.After(integrationTimeout):
+		t.Fatalf("no ticker of %s within %s", integrationSymbol, integrationTimeout)
+	}
+}
+
+func TestIntegrationSymbol(t *testing.T) {
+	client := newIntegrationClient(t)
+
+	symbol, err := client.GetSymbol(integrationSymbol)
+	require.NoError(t, err)
+	require.Equal(t, integrationSymbol, symbol.ID)
+	_, err = symbol.TickSizeDecimal()
+	require.NoError(t, err)
+}
@@ -0,0 +1,42 @@
+package hitbtc
+
+import "github.com/juju/errors"
+
+// feedMethods maps the notification methods to the kind of their feed.
+var feedMethods = map[string]FeedKind{
+	"ticker":            FeedTicker,
+	"snapshotOrderbook": FeedOrderbook,
+	"updateOrderbook":   FeedOrderbook,
+	"snapshotTrades":    FeedTrades,
+	"updateTrades":      FeedTrades,
+	"snapshotCandles":   FeedCandles,
+	"updateCandles":     FeedCandles,
+	"activeOrders":      FeedReports,
+	"report":            FeedReports,
+	"balance":           FeedBalance,
+}
+
+// feedKinds is a set of feed kinds.
+type feedKinds uint
+
+func (k feedKinds) has(kind FeedKind) bool {
+	return k&(1<<uint(kind)) != 0
+}
+
+// skips reports whether the notifications of the method are of a feed kind
+// disabled by WithFeedKinds, which are not decoded.
+func (h *responseChannels) skips(method string) bool {
+	if h.kinds == 0 {
+		return false
+	}
+	kind, ok := feedMethods[method]
+	return ok && !h.kinds.has(kind)
+}
+
+// checkKind fails when the kind of feed is disabled by WithFeedKinds.
+func (c *WSClient) checkKind(kind FeedKind) error {
+	if c.options.kinds != 0 && !c.options.kinds.has(kind) {
+		return errors.NotSupportedf("%s feed disabled by WithFeedKinds", kind)
+	}
+	return nil
+}
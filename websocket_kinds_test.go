@@ -0,0 +1,66 @@
+package hitbtc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/juju/errors"
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWSFeedKinds(t *testing.T) {
+	server := newMockServer(t, nil)
+	var raw []string
+	client := newTestClient(t, server, WithFeedKinds(FeedOrderbook, FeedBalance), WithRawHandler(func(method string, params json.RawMessage) {
+		raw = append(raw, method)
+	}))
+
+	_, err := client.Subscribe(FeedTicker, "ETHBTC")
+	require.True(t, errors.IsNotSupported(errors.Cause(err)), err)
+	_, _, err = client.SubscribeReports(context.Background())
+	require.True(t, errors.IsNotSupported(errors.Cause(err)), err)
+	require.Empty(t, server.calls("subscribeTicker"))
+	_, err = client.Subscribe(FeedOrderbook, "ETHBTC")
+	require.NoError(t, err)
+
+	// the notifications of the other kinds are not decoded, even if malformed.
+	h := client.updates
+	h.Handle(context.Background(), nil, &jsonrpc2.Request{Method: "ticker", Params: rawParams(`{"symbol":1}`), Notif: true})
+	h.Handle(context.Background(), nil, &jsonrpc2.Request{Method: "unknownMethod", Params: rawParams(`{}`), Notif: true})
+	require.Equal(t, []string{"ticker", "unknownMethod"}, raw)
+	require.Zero(t, client.Snapshot().Errors)
+}
+
+// rawParams returns the params of a notification.
+func rawParams(params string) *json.RawMessage {
+	raw := json.RawMessage(params)
+	return &raw
+}
+
+func BenchmarkWSFeedKinds(b *testing.B) {
+	ticker, err := json.Marshal(WSNotificationTickerResponse{Symbol: "ETHBTC", Ask: "0.051", Bid: "0.05", Last: "0.05"})
+	require.NoError(b, err)
+	params := json.RawMessage(ticker)
+	req := &jsonrpc2.Request{Method: "ticker", Params: &params, Notif: true}
+	for _, bench := range []struct {
+		name  string
+		kinds feedKinds
+	}{
+		{"all", 0},
+		{"orderbook", 1 << uint(FeedOrderbook)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			h := newResponseChannels()
+			h.kinds = bench.kinds
+			drainOrderbookUpdates(h.subscribe(FeedOrderbook, "ETHBTC"))
+			defer h.closeAll()
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.Handle(context.Background(), nil, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package hitbtc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// defaultWSTimeout bounds the websocket calls made without an explicit context.
+const defaultWSTimeout = 30 * time.Second
+
+// Option configures a WSClient.
+type Option func(*wsOptions)
+
+// wsOptions holds the configuration of a WSClient.
+type wsOptions struct {
+	ctx            context.Context
+	url            string
+	defaultTimeout time.Duration
+	readTimeout    time.Duration // of the market data calls, defaultTimeout when zero
+	tradeTimeout   time.Duration // of the trading calls, defaultTimeout when zero
+	autoRelogin    bool
+	hmacLogin      bool
+	reconnectMin   time.Duration // between the automatic reconnections, disabled when zero
+	reconnectMax   time.Duration
+	rawHandler     RawHandler
+	header         http.Header
+	subprotocols   []string
+	recorder       *Recorder
+	replay         *ReplaySource
+	aliases        map[string]string
+	normalizer     func(string) string
+	callHook       CallHook
+	credentials    []Credential // logged in with on creation, see WithCredentials
+
+	overflowFeedSize int
+	fastDecoding     bool
+	rawNotifications bool
+	explicitChannels bool
+	kinds            feedKinds // handled, every kind when empty
+
+	circuitFailures int // opening the circuit of PlaceOrder, disabled when zero
+	circuitCoolDown time.Duration
+}
+
+func newWSOptions(opts []Option) wsOptions {
+	o := wsOptions{
+		ctx:            context.Background(),
+		url:            wsAPIURL,
+		defaultTimeout: defaultWSTimeout,
+		autoRelogin:    true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithCredentials logs the client in with the first of the API keys on
+// creation, NewWSClient failing if it cannot.
+//
+// The keys are rotated in turn: a call failing because its key is rate
+// limited (code 429) or not allowed the action (CodeActionForbidden) logs the
+// session in with the next key, and is retried once with it. The calls
+// failing together with the same key rotate it once. Login replaces the key
+// until the next rotation.
+func WithCredentials(credentials []Credential) Option {
+	return func(o *wsOptions) {
+		o.credentials = append([]Credential(nil), credentials...)
+	}
+}
+
+// WithURL sets the websocket endpoint, the hitbtc api by default. The methods
+// of an endpoint without wrapper in the client are reached with WSClient.Call.
+func WithURL(url string) Option {
+	return func(o *wsOptions) {
+		o.url = url
+	}
+}
+
+// WithContext sets the root context of the client, the background context by
+// default. Cancelling it closes the client, as Close does.
+func WithContext(ctx context.Context) Option {
+	return func(o *wsOptions) {
+		if ctx != nil {
+			o.ctx = ctx
+		}
+	}
+}
+
+// WithDefaultTimeout sets the timeout of the calls made without an explicit
+// context, 30 seconds by default.
+func WithDefaultTimeout(d time.Duration) Option {
+	return func(o *wsOptions) {
+		if d > 0 {
+			o.defaultTimeout = d
+		}
+	}
+}
+
+// methodClass is the category of a websocket method, for its timeout.
+type methodClass int
+
+const (
+	readMethod  methodClass = iota // market data
+	tradeMethod                    // order placement and replacement
+)
+
+// timeout returns the default timeout of the methods of the class.
+func (o wsOptions) timeout(class methodClass) time.Duration {
+	switch {
+	case class == readMethod && o.readTimeout > 0:
+		return o.readTimeout
+	case class == tradeMethod && o.tradeTimeout > 0:
+		return o.tradeTimeout
+	}
+	return o.defaultTimeout
+}
+
+// WithReadTimeout sets the timeout of the market data calls, such as
+// GetSymbol, the default timeout when not set.
+func WithReadTimeout(d time.Duration) Option {
+	return func(o *wsOptions) {
+		if d > 0 {
+			o.readTimeout = d
+		}
+	}
+}
+
+// WithTradeTimeout sets the timeout of the trading calls, such as
+// ReplaceOrder, applied when their context has no earlier deadline. The
+// default timeout is used when not set.
+func WithTradeTimeout(d time.Duration) Option {
+	return func(o *wsOptions) {
+		if d > 0 {
+			o.tradeTimeout = d
+		}
+	}
+}
+
+// WithAutoRelogin enables or disables logging in again and retrying a private
+// call once when hitbtc reports that the authorization of the session expired
+// (error codes 1001 and 1002). It is enabled by default.
+func WithAutoRelogin(enabled bool) Option {
+	return func(o *wsOptions) {
+		o.autoRelogin = enabled
+	}
+}
+
+// WithAutoReconnect reconnects automatically when the connection is lost, as
+// Reconnect does, including when hitbtc closes it normally. The failed attempts
+// are retried after a delay doubling from minDelay up to maxDelay, until the
+// client is closed. Each reconnection is reported to Reconnections, so that the
+// consumers can reset the state built from the feeds, e.g. an order book,
+// before the new snapshots.
+func WithAutoReconnect(minDelay, maxDelay time.Duration) Option {
+	return func(o *wsOptions) {
+		if minDelay <= 0 {
+			minDelay = time.Second
+		}
+		if maxDelay < minDelay {
+			maxDelay = minDelay
+		}
+		o.reconnectMin, o.reconnectMax = minDelay, maxDelay
+	}
+}
+
+// WithHMACLogin logs in with the HS256 algorithm: the secret key signs a
+// random nonce and is never sent, rather than with the BASIC one sending both
+// keys.
+func WithHMACLogin() Option {
+	return func(o *wsOptions) {
+		o.hmacLogin = true
+	}
+}
+
+// WithHeader adds a header to the websocket handshake requests, e.g. an api
+// version header or a header expected by a proxy.
+func WithHeader(key, value string) Option {
+	return func(o *wsOptions) {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+		o.header.Add(key, value)
+	}
+}
+
+// WithSubprotocols sets the websocket subprotocols requested during the handshake.
+func WithSubprotocols(subprotocols ...string) Option {
+	return func(o *wsOptions) {
+		o.subprotocols = subprotocols
+	}
+}
+
+// WithRecorder records the inbound frames of the client with the recorder.
+func WithRecorder(r *Recorder) Option {
+	return func(o *wsOptions) {
+		o.recorder = r
+	}
+}
+
+// WithReplaySource connects the client to the replay source instead of the
+// hitbtc api, to replay a recorded session offline.
+func WithReplaySource(s *ReplaySource) Option {
+	return func(o *wsOptions) {
+		o.replay = s
+	}
+}
+
+// WithSymbolNormalizer maps the symbols of the caller, e.g. "BTC-USD", to the
+// ids of hitbtc, e.g. "BTCUSD", before any alias, see ResolveSymbol. The
+// notifications of the subscriptions carry the symbol of the caller back: the
+// last one normalized to the id of their market.
+func WithSymbolNormalizer(normalize func(string) string) Option {
+	return func(o *wsOptions) {
+		o.normalizer = normalize
+	}
+}
+
+// WithSymbolAliases maps former symbols to the current ids of the renamed
+// markets, e.g. "BTCUSD" to "BTCUSDT", see ResolveSymbol.
+func WithSymbolAliases(aliases map[string]string) Option {
+	return func(o *wsOptions) {
+		if o.aliases == nil {
+			o.aliases = make(map[string]string, len(aliases))
+		}
+		for alias, id := range aliases {
+			o.aliases[alias] = id
+		}
+	}
+}
+
+// WithOverflowFeed enables the OverflowFeed of the client, buffering up to
+// size events until they are read.
+func WithOverflowFeed(size int) Option {
+	return func(o *wsOptions) {
+		o.overflowFeedSize = size
+	}
+}
+
+// WithFastDecoding decodes the order book updates, the hottest notifications,
+// with a decoder specialized for their plain form instead of encoding/json,
+// which still decodes the other forms. The decoded updates are the same.
+func WithFastDecoding() Option {
+	return func(o *wsOptions) {
+		o.fastDecoding = true
+	}
+}
+
+// WithRawNotifications keeps the params of every notification, as received,
+// in the Raw field of the values delivered, e.g. to archive the exact wire
+// format of the stream while using the decoded values, see
+// Recorder.RecordNotification. The balance changes carry no raw params.
+func WithRawNotifications() Option {
+	return func(o *wsOptions) {
+		o.rawNotifications = true
+	}
+}
+
+// WithFeedKinds restricts the client to the feeds of the given kinds, e.g.
+// FeedOrderbook alone, so that the notifications of the other kinds are not
+// decoded: they are only delivered to the RawHandler, if any. Subscribing to
+// a feed of another kind fails.
+//
+// Without kinds, the notifications of every kind are handled.
+func WithFeedKinds(kinds ...FeedKind) Option {
+	return func(o *wsOptions) {
+		for _, kind := range kinds {
+			o.kinds |= 1 << uint(kind)
+		}
+	}
+}
+
+// WithExplicitChannels requires the channels of every feed to be registered
+// with RegisterChannels before subscribing to it: subscribing to a feed that
+// was not registered returns ErrChannelsNotRegistered, instead of creating
+// channels that may be read too late and block the connection reader.
+func WithExplicitChannels() Option {
+	return func(o *wsOptions) {
+		o.explicitChannels = true
+	}
+}
+
+// CallHook receives the method and the JSON RPC id of every call sent by the
+// client, to correlate the calls with the hitbtc logs. The ids are numbers,
+// formatted as in the requests.
+//
+// It is called before the request is written and must not block.
+type CallHook func(method string, id string)
+
+// WithCallHook sets the hook receiving the ids of the calls.
+func WithCallHook(hook CallHook) Option {
+	return func(o *wsOptions) {
+		o.callHook = hook
+	}
+}
+
+// RawHandler receives the notifications that are not routed to a feed: the
+// methods unknown to the client, the notifications without params, which are
+// delivered with nil params, and the activeOrders snapshots received without
+// reports subscription, which hitbtc may send right after login.
+//
+// It is called from the connection reader and must not block.
+type RawHandler func(method string, params json.RawMessage)
+
+// WithRawHandler sets the handler of the notifications not routed to a feed,
+// making them observable for protocol debugging.
+func WithRawHandler(handler RawHandler) Option {
+	return func(o *wsOptions) {
+		o.rawHandler = handler
+	}
+}
+
+// SubOption configures a single websocket subscription.
+type SubOption func(*subOptions)
+
+// subOptions holds the configuration of a subscription.
+type subOptions struct {
+	coalesce bool
+	interval string
+	period   string
+	limit    int // of the trades snapshot
+
+	closedCandles bool
+	candleBars    bool
+	maintainBook  bool
+	reuseBuffers  bool
+	fillsOnly     bool
+	changesOnly   bool // of the tickers
+}
+
+func newSubOptions(opts []SubOption) subOptions {
+	var o subOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithCoalescing merges the pending order book updates of a symbol while the
+// consumer is not reading them, so that a slow consumer receives a single
+// update carrying the latest state of every changed level (and the highest
+// sequence) instead of every intermediate delta.
+//
+// This trades completeness for freshness: the intermediate updates are lost.
+// It only applies to order book subscriptions.
+func WithCoalescing() SubOption {
+	return func(o *subOptions) {
+		o.coalesce = true
+	}
+}
+
+// WithReusedBuffers decodes the order book updates into buffers of the
+// subscription, sparing the allocation of their level slices. The slices of a
+// delivered update are only valid until the next update is read: an update
+// retained longer must be copied, see WSNotificationOrderbookUpdate.Clone.
+//
+// It is ignored with WithCoalescing, which retains the updates.
+func WithReusedBuffers() SubOption {
+	return func(o *subOptions) {
+		o.reuseBuffers = true
+	}
+}
+
+// WithMaintainedOrderBook maintains an OrderBook from the notifications of an
+// order book subscription, see Subscription.OrderBook.
+func WithMaintainedOrderBook() SubOption {
+	return func(o *subOptions) {
+		o.maintainBook = true
+	}
+}
+
+// WithTickerInterval sets the update interval of a ticker subscription, e.g. "1s" or "3s",
+// reducing the message volume of many subscribed symbols.
+// The server default is used when it is not set.
+func WithTickerInterval(interval string) SubOption {
+	return func(o *subOptions) {
+		o.interval = interval
+	}
+}
+
+// WithTradesLimit sets the number of trades of the snapshot of a trades
+// subscription, the server default when zero or negative.
+func WithTradesLimit(n int) SubOption {
+	return func(o *subOptions) {
+		if n > 0 {
+			o.limit = n
+		}
+	}
+}
+
+// WithTickerChangesOnly only delivers a ticker on the channel of a ticker
+// subscription when its bid, ask or last price changed since the previous
+// ticker, hitbtc pushing the tickers periodically even when nothing moved. The
+// tickers skipped are still recorded, see WSClient.Snapshot.
+func WithTickerChangesOnly() SubOption {
+	return func(o *subOptions) {
+		o.changesOnly = true
+	}
+}
+
+// WithPeriod sets the period of a candles subscription, e.g. Interval30Minutes.
+func WithPeriod(period string) SubOption {
+	return func(o *subOptions) {
+		o.period = period
+	}
+}
+
+// WithClosedCandles only delivers a candle on the updates channel of a candles
+// subscription once it is closed, i.e. when an update of the next period is
+// received, instead of on every change of the in-progress candle.
+//
+// A candle is therefore delivered one period late at most, and the snapshot is
+// delivered as is.
+func WithClosedCandles() SubOption {
+	return func(o *subOptions) {
+		o.closedCandles = true
+	}
+}
+
+// WithCandleBars delivers the closed candles of a candles subscription as
+// CandleBar, with exact decimals, on Subscription.CandleBars instead of the
+// updates channel. It implies WithClosedCandles, and the snapshots are not
+// delivered: only the bars completed after subscribing are.
+func WithCandleBars() SubOption {
+	return func(o *subOptions) {
+		o.closedCandles = true
+		o.candleBars = true
+	}
+}
+
+// WithFillsOnly only delivers the reports of type ReportTypeTrade, the fills,
+// on the channel of a reports subscription. The active orders snapshot, and
+// the synthetic reports of a reconnection, are delivered in full.
+func WithFillsOnly() SubOption {
+	return func(o *subOptions) {
+		o.fillsOnly = true
+	}
+}
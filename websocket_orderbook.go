@@ -0,0 +1,524 @@
+package hitbtc
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/shopspring/decimal"
+)
+
+// PriceLevel is a price level of an order book.
+type PriceLevel struct {
+	Price decimal.Decimal
+	Size  decimal.Decimal
+}
+
+// OrderBook maintains the order book of a market from the websocket snapshots
+// and updates. It is safe for concurrent use.
+type OrderBook struct {
+	mu       sync.RWMutex
+	symbol   string
+	sequence int64
+	ready    bool         // set once a snapshot is applied
+	bids     []PriceLevel // sorted by bidOrder
+	asks     []PriceLevel // sorted by askOrder
+
+	// bidOrder and askOrder sort the sides, nil keeping the server order.
+	bidOrder, askOrder LevelOrder
+	byPrice            bool // the default orders, best first
+
+	topChanges chan TopOfBookChange // nil unless WithTopOfBookChanges
+
+	deletes func(PriceLevel) bool // see WithDeletion
+}
+
+// OrderBookOption configures an OrderBook.
+type OrderBookOption func(*OrderBook)
+
+// LevelOrder orders the levels of a side of an order book, reporting whether a
+// is placed before b.
+type LevelOrder func(a, b PriceLevel) bool
+
+// WithLevelOrder sets the order of the bids and of the asks, by default by
+// descending and ascending price, best first. A nil order keeps the levels of
+// the side in the server order, see WithUnsortedLevels.
+func WithLevelOrder(bids, asks LevelOrder) OrderBookOption {
+	return func(b *OrderBook) {
+		b.bidOrder, b.askOrder = bids, asks
+		b.byPrice = false
+	}
+}
+
+// WithUnsortedLevels keeps the levels in the server order, sparing the sort on
+// every update: the levels of a snapshot as sent, a changed level in place and
+// the new levels of an update appended. The best bid and ask are still the
+// highest and lowest prices.
+func WithUnsortedLevels() OrderBookOption {
+	return WithLevelOrder(nil, nil)
+}
+
+// WithDeletion sets the predicate telling whether a level received removes its
+// price from the order book, by default when its size is zero, in any form such
+// as "0", "0.00" or "0E-8", the sizes being compared as decimals. A level of a
+// snapshot matching it is skipped.
+func WithDeletion(deletes func(level PriceLevel) bool) OrderBookOption {
+	return func(b *OrderBook) {
+		if deletes != nil {
+			b.deletes = deletes
+		}
+	}
+}
+
+// WithTopOfBookChanges enables the TopChanges channel of the order book.
+func WithTopOfBookChanges() OrderBookOption {
+	return func(b *OrderBook) {
+		b.topChanges = make(chan TopOfBookChange, 1)
+	}
+}
+
+// TopOfBookChange is a change of the best bid or ask of an order book. A side
+// without level has a zero PriceLevel.
+type TopOfBookChange struct {
+	Symbol   string
+	Sequence int64
+
+	BestBid PriceLevel
+	BestAsk PriceLevel
+
+	PreviousBestBid PriceLevel
+	PreviousBestAsk PriceLevel
+}
+
+// NewOrderBook returns an empty order book of the market.
+func NewOrderBook(symbol string, opts ...OrderBookOption) *OrderBook {
+	b := &OrderBook{
+		symbol:   symbol,
+		bidOrder: func(a, b PriceLevel) bool { return a.Price.GreaterThan(b.Price) },
+		askOrder: func(a, b PriceLevel) bool { return a.Price.LessThan(b.Price) },
+		byPrice:  true,
+		deletes:  func(level PriceLevel) bool { return level.Size.IsZero() },
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// TopChanges returns the changes of the best bid or ask, the price or the size
+// of a level, when enabled with WithTopOfBookChanges, nil otherwise. The
+// changes of the deeper levels are not reported.
+//
+// A change not read yet is merged with the next one, keeping its previous
+// levels, so that a slow reader gets the latest top of book.
+func (b *OrderBook) TopChanges() <-chan TopOfBookChange {
+	return b.topChanges
+}
+
+// bestLevels returns the best bid and ask, zero when a side is empty. It must
+// be called with mu held.
+func (b *OrderBook) bestLevels() (bid, ask PriceLevel) {
+	bid, _ = b.best(b.bids, decimal.Decimal.GreaterThan)
+	ask, _ = b.best(b.asks, decimal.Decimal.LessThan)
+	return bid, ask
+}
+
+// best returns the best of the levels, false if there is none. It must be
+// called with mu held.
+func (b *OrderBook) best(levels []PriceLevel, better func(a, b decimal.Decimal) bool) (PriceLevel, bool) {
+	if len(levels) == 0 {
+		return PriceLevel{}, false
+	}
+	if b.byPrice {
+		return levels[0], true
+	}
+	best := levels[0]
+	for _, level := range levels[1:] {
+		if better(level.Price, best.Price) {
+			best = level
+		}
+	}
+	return best, true
+}
+
+// notifyTop reports a change of the top of book since bid and ask. It must be
+// called with mu held.
+func (b *OrderBook) notifyTop(bid, ask PriceLevel) {
+	if b.topChanges == nil {
+		return
+	}
+	change := TopOfBookChange{Symbol: b.symbol, Sequence: b.sequence, PreviousBestBid: bid, PreviousBestAsk: ask}
+	change.BestBid, change.BestAsk = b.bestLevels()
+	if samePriceLevel(change.BestBid, bid) && samePriceLevel(change.BestAsk, ask) {
+		return
+	}
+
+	// the writers are serialized by mu, the pending change can only be read meanwhile.
+	select {
+	case pending := <-b.topChanges:
+		change.PreviousBestBid, change.PreviousBestAsk = pending.PreviousBestBid, pending.PreviousBestAsk
+	default:
+	}
+	b.topChanges <- change
+}
+
+func samePriceLevel(a, b PriceLevel) bool {
+	return a.Price.Equal(b.Price) && a.Size.Equal(b.Size)
+}
+
+// Symbol returns the market of the order book.
+func (b *OrderBook) Symbol() string {
+	return b.symbol
+}
+
+// Sequence returns the sequence of the last applied snapshot or update.
+func (b *OrderBook) Sequence() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.sequence
+}
+
+// ApplySnapshot replaces the content of the order book by the snapshot.
+func (b *OrderBook) ApplySnapshot(snapshot WSNotificationOrderbookSnapshot) error {
+	bids, err := parseLevels(snapshot.Bid)
+	if err != nil {
+		return errors.Annotate(err, "bid")
+	}
+	asks, err := parseLevels(snapshot.Ask)
+	if err != nil {
+		return errors.Annotate(err, "ask")
+	}
+	bids, asks = b.skipDeletions(bids), b.skipDeletions(asks)
+	sortLevels(bids, b.bidOrder)
+	sortLevels(asks, b.askOrder)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bid, ask := b.bestLevels()
+	b.bids = bids
+	b.asks = asks
+	b.sequence = snapshot.Sequence
+	b.ready = true
+	b.notifyTop(bid, ask)
+	return nil
+}
+
+// ApplyUpdate applies the changed levels of the update, a level of size zero
+// being removed, see WithDeletion. Updates older than the order book are
+// ignored.
+func (b *OrderBook) ApplyUpdate(update WSNotificationOrderbookUpdate) error {
+	_, _, err := b.ApplyUpdateChanges(update)
+	return err
+}
+
+// ApplyUpdateChanges applies the update as ApplyUpdate and returns the bid and
+// ask levels it changed, in the order of the update, a removed level having a
+// zero size unless set otherwise by WithDeletion. The levels of the update
+// equal to those of the order book, or removing a missing price, are not
+// returned.
+func (b *OrderBook) ApplyUpdateChanges(update WSNotificationOrderbookUpdate) (bids, asks []PriceLevel, err error) {
+	updatedBids, err := parseLevels(update.Bid)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "bid")
+	}
+	updatedAsks, err := parseLevels(update.Ask)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "ask")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.ready {
+		return nil, nil, ErrOrderBookNotReady
+	}
+	if update.Sequence <= b.sequence {
+		return nil, nil, nil
+	}
+	bid, ask := b.bestLevels()
+	var changed bool
+	for _, level := range updatedBids {
+		if b.bids, changed = b.applyLevel(b.bids, level, b.bidOrder); changed {
+			bids = append(bids, level)
+		}
+	}
+	for _, level := range updatedAsks {
+		if b.asks, changed = b.applyLevel(b.asks, level, b.askOrder); changed {
+			asks = append(asks, level)
+		}
+	}
+	b.sequence = update.Sequence
+	b.notifyTop(bid, ask)
+	return bids, asks, nil
+}
+
+// Bids returns a copy of the bids, best first unless set by WithLevelOrder.
+func (b *OrderBook) Bids() []PriceLevel {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]PriceLevel(nil), b.bids...)
+}
+
+// Asks returns a copy of the asks, best first unless set by WithLevelOrder.
+func (b *OrderBook) Asks() []PriceLevel {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]PriceLevel(nil), b.asks...)
+}
+
+// BestBid returns the highest bid, false if there is none.
+func (b *OrderBook) BestBid() (PriceLevel, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.best(b.bids, decimal.Decimal.GreaterThan)
+}
+
+// BestAsk returns the lowest ask, false if there is none.
+func (b *OrderBook) BestAsk() (PriceLevel, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.best(b.asks, decimal.Decimal.LessThan)
+}
+
+// top returns the best bid and ask, or ErrEmptyOrderBook if a side is empty.
+func (b *OrderBook) top() (bid, ask PriceLevel, err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bid, hasBid := b.best(b.bids, decimal.Decimal.GreaterThan)
+	ask, hasAsk := b.best(b.asks, decimal.Decimal.LessThan)
+	if !hasBid || !hasAsk {
+		return bid, ask, ErrEmptyOrderBook
+	}
+	return bid, ask, nil
+}
+
+// Spread returns the difference between the best ask and the best bid.
+func (b *OrderBook) Spread() (decimal.Decimal, error) {
+	bid, ask, err := b.top()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return ask.Price.Sub(bid.Price), nil
+}
+
+// MidPrice returns the average of the best bid and the best ask.
+func (b *OrderBook) MidPrice() (decimal.Decimal, error) {
+	bid, ask, err := b.top()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return bid.Price.Add(ask.Price).Div(decimal.NewFromInt(2)), nil
+}
+
+// Microprice returns the mid price weighted by the sizes of the best bid and
+// ask, (bid price * ask size + ask price * bid size) / (bid size + ask size):
+// the closer to the ask the larger the bid size is, as the next trade is more
+// likely to lift the ask. It returns ErrEmptyOrderBook when both best sizes
+// are zero, which a book kept by WithDeletion may hold.
+func (b *OrderBook) Microprice() (decimal.Decimal, error) {
+	bid, ask, err := b.top()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	total := bid.Size.Add(ask.Size)
+	if total.IsZero() {
+		return decimal.Zero, ErrEmptyOrderBook
+	}
+	weighted := bid.Price.Mul(ask.Size).Add(ask.Price.Mul(bid.Size))
+	return weighted.Div(total), nil
+}
+
+// BookDiff is the difference between two states of an order book, see
+// OrderBook.Diff.
+type BookDiff struct {
+	Bids LevelsDiff
+	Asks LevelsDiff
+}
+
+// LevelsDiff is the difference between the levels of a side of two states of
+// an order book.
+type LevelsDiff struct {
+	Added   []PriceLevel // in the other state only
+	Removed []PriceLevel // in the first state only
+	Changed []PriceLevel // in both with another size, with the size of the other state
+}
+
+// Empty reports whether the states have the same levels.
+func (d BookDiff) Empty() bool {
+	return d.Bids.empty() && d.Asks.empty()
+}
+
+func (d LevelsDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff returns the levels added, removed and changed from the order book to
+// the other one, e.g. to check a new snapshot against the order book before a
+// gap. The added and changed levels are in the order of the other book, the
+// removed ones in the order of the book. The sequences are not compared.
+func (b *OrderBook) Diff(other *OrderBook) BookDiff {
+	bids, asks := b.levels()
+	otherBids, otherAsks := other.levels()
+	return BookDiff{
+		Bids: diffLevels(bids, otherBids),
+		Asks: diffLevels(asks, otherAsks),
+	}
+}
+
+// levels returns a copy of both sides, consistent with each other.
+func (b *OrderBook) levels() (bids, asks []PriceLevel) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]PriceLevel(nil), b.bids...), append([]PriceLevel(nil), b.asks...)
+}
+
+// diffLevels returns the difference from the levels to the other ones.
+func diffLevels(levels, other []PriceLevel) LevelsDiff {
+	sizes := make(map[string]decimal.Decimal, len(levels))
+	for _, level := range levels {
+		sizes[level.Price.String()] = level.Size
+	}
+
+	var diff LevelsDiff
+	for _, level := range other {
+		price := level.Price.String()
+		size, ok := sizes[price]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, level)
+		case !size.Equal(level.Size):
+			diff.Changed = append(diff.Changed, level)
+		}
+		delete(sizes, price)
+	}
+	for _, level := range levels {
+		if _, ok := sizes[level.Price.String()]; ok {
+			diff.Removed = append(diff.Removed, level)
+		}
+	}
+	return diff
+}
+
+// BestBid returns the highest bid of the snapshot, false if there is none.
+func (s WSNotificationOrderbookSnapshot) BestBid() (PriceLevel, bool) {
+	return bestLevel(s.Bid, decimal.Decimal.GreaterThan)
+}
+
+// BestAsk returns the lowest ask of the snapshot, false if there is none.
+func (s WSNotificationOrderbookSnapshot) BestAsk() (PriceLevel, bool) {
+	return bestLevel(s.Ask, decimal.Decimal.LessThan)
+}
+
+// BestBid returns the highest bid set by the update, the removed levels being
+// skipped, false if there is none.
+func (u WSNotificationOrderbookUpdate) BestBid() (PriceLevel, bool) {
+	return bestLevel(u.Bid, decimal.Decimal.GreaterThan)
+}
+
+// BestAsk returns the lowest ask set by the update, the removed levels being
+// skipped, false if there is none.
+func (u WSNotificationOrderbookUpdate) BestAsk() (PriceLevel, bool) {
+	return bestLevel(u.Ask, decimal.Decimal.LessThan)
+}
+
+// bestLevel returns the best of the levels of non zero size, whatever their
+// order, skipping the levels that cannot be parsed.
+func bestLevel(levels []WSSubtypeTrade, better func(a, b decimal.Decimal) bool) (PriceLevel, bool) {
+	var best PriceLevel
+	found := false
+	for _, level := range levels {
+		price, err := decimal.NewFromString(level.Price)
+		if err != nil {
+			continue
+		}
+		size, err := decimal.NewFromString(level.Size)
+		if err != nil || size.IsZero() {
+			continue
+		}
+		if !found || better(price, best.Price) {
+			best, found = PriceLevel{Price: price, Size: size}, true
+		}
+	}
+	return best, found
+}
+
+func parseLevels(levels []WSSubtypeTrade) ([]PriceLevel, error) {
+	parsed := make([]PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		price, err := parseDecimal("price", level.Price)
+		if err != nil {
+			return nil, err
+		}
+		size, err := parseDecimal("size", level.Size)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, PriceLevel{Price: price, Size: size})
+	}
+	return parsed, nil
+}
+
+// sortLevels sorts the levels of a side, unless order is nil.
+func sortLevels(levels []PriceLevel, order LevelOrder) {
+	if order != nil {
+		sort.SliceStable(levels, func(i, j int) bool { return order(levels[i], levels[j]) })
+	}
+}
+
+// applyLevel sets the level in the levels sorted by order, removing it when it
+// is a deletion, and reports whether the levels changed. It must be called with
+// mu held.
+func (b *OrderBook) applyLevel(levels []PriceLevel, level PriceLevel, order LevelOrder) ([]PriceLevel, bool) {
+	var i int
+	var found bool
+	if b.byPrice {
+		i = sort.Search(len(levels), func(i int) bool { return !order(levels[i], level) })
+		found = i < len(levels) && levels[i].Price.Equal(level.Price)
+	} else {
+		i, found = indexOfPrice(levels, level.Price)
+	}
+
+	deletion := b.deletes(level)
+	switch {
+	case deletion && found:
+		return append(levels[:i], levels[i+1:]...), true
+	case deletion, found && levels[i].Size.Equal(level.Size):
+		return levels, false
+	case found && (b.byPrice || order == nil):
+		levels[i] = level
+		return levels, true
+	case found:
+		// the position of the level may depend on its size.
+		levels = append(levels[:i], levels[i+1:]...)
+	}
+	if !b.byPrice {
+		i = len(levels)
+		if order != nil {
+			i = sort.Search(len(levels), func(i int) bool { return order(level, levels[i]) })
+		}
+	}
+	levels = append(levels, PriceLevel{})
+	copy(levels[i+1:], levels[i:])
+	levels[i] = level
+	return levels, true
+}
+
+// skipDeletions returns the levels but the deletions, in place.
+func (b *OrderBook) skipDeletions(levels []PriceLevel) []PriceLevel {
+	kept := levels[:0]
+	for _, level := range levels {
+		if !b.deletes(level) {
+			kept = append(kept, level)
+		}
+	}
+	return kept
+}
+
+// indexOfPrice returns the index of the level of the price in unsorted levels.
+func indexOfPrice(levels []PriceLevel, price decimal.Decimal) (int, bool) {
+	for i, level := range levels {
+		if level.Price.Equal(price) {
+			return i, true
+		}
+	}
+	return len(levels), false
+}
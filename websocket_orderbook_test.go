@@ -0,0 +1,238 @@
+package hitbtc
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func levels(book []PriceLevel) [][2]string {
+	var out [][2]string
+	for _, level := range book {
+		out = append(out, [2]string{level.Price.String(), level.Size.String()})
+	}
+	return out
+}
+
+func TestOrderBook(t *testing.T) {
+	book := NewOrderBook("ETHBTC")
+	require.Equal(t, ErrOrderBookNotReady, book.ApplyUpdate(WSNotificationOrderbookUpdate{Sequence: 1}))
+	_, err := book.Spread()
+	require.Equal(t, ErrEmptyOrderBook, err)
+	_, err = book.Microprice()
+	require.Equal(t, ErrEmptyOrderBook, err)
+
+	require.NoError(t, book.ApplySnapshot(WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.052", Size: "2"}, {Price: "0.051", Size: "1"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.049", Size: "3"}, {Price: "0.050", Size: "4"}},
+		Symbol:   "ETHBTC",
+		Sequence: 10,
+	}))
+	require.Equal(t, [][2]string{{"0.051", "1"}, {"0.052", "2"}}, levels(book.Asks()))
+	require.Equal(t, [][2]string{{"0.05", "4"}, {"0.049", "3"}}, levels(book.Bids()))
+
+	require.NoError(t, book.ApplyUpdate(WSNotificationOrderbookUpdate{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "0"}, {Price: "0.0515", Size: "5"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.0495", Size: "6"}, {Price: "0.050", Size: "1"}},
+		Sequence: 11,
+	}))
+	// stale update
+	require.NoError(t, book.ApplyUpdate(WSNotificationOrderbookUpdate{
+		Ask:      []WSSubtypeTrade{{Price: "0.040", Size: "1"}},
+		Sequence: 11,
+	}))
+	require.Equal(t, int64(11), book.Sequence())
+	require.Equal(t, [][2]string{{"0.0515", "5"}, {"0.052", "2"}}, levels(book.Asks()))
+	require.Equal(t, [][2]string{{"0.05", "1"}, {"0.0495", "6"}, {"0.049", "3"}}, levels(book.Bids()))
+
+	spread, err := book.Spread()
+	require.NoError(t, err)
+	require.True(t, spread.Equal(decimal.RequireFromString("0.0015")), spread.String())
+	mid, err := book.MidPrice()
+	require.NoError(t, err)
+	require.True(t, mid.Equal(decimal.RequireFromString("0.05075")), mid.String())
+	microprice, err := book.Microprice()
+	require.NoError(t, err)
+	require.True(t, microprice.Equal(decimal.RequireFromString("0.05025")), microprice.String())
+}
+
+func TestOrderBookTopChanges(t *testing.T) {
+	book := NewOrderBook("ETHBTC", WithTopOfBookChanges())
+	require.Nil(t, NewOrderBook("ETHBTC").TopChanges())
+
+	require.NoError(t, book.ApplySnapshot(WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "1"}, {Price: "0.052", Size: "2"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.050", Size: "4"}},
+		Sequence: 1,
+	}))
+	change := <-book.TopChanges()
+	require.Equal(t, [][2]string{{"0.05", "4"}, {"0.051", "1"}}, levels([]PriceLevel{change.BestBid, change.BestAsk}))
+	require.True(t, change.PreviousBestBid.Price.IsZero())
+
+	// deeper level only
+	require.NoError(t, book.ApplyUpdate(WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "0.052", Size: "3"}}, Sequence: 2}))
+	require.Empty(t, book.TopChanges())
+
+	// two changes not read are merged
+	require.NoError(t, book.ApplyUpdate(WSNotificationOrderbookUpdate{Bid: []WSSubtypeTrade{{Price: "0.050", Size: "5"}}, Sequence: 3}))
+	require.NoError(t, book.ApplyUpdate(WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "0.051", Size: "0"}}, Sequence: 4}))
+	change = <-book.TopChanges()
+	require.Equal(t, int64(4), change.Sequence)
+	require.Equal(t, [][2]string{{"0.05", "4"}, {"0.051", "1"}}, levels([]PriceLevel{change.PreviousBestBid, change.PreviousBestAsk}))
+	require.Equal(t, [][2]string{{"0.05", "5"}, {"0.052", "3"}}, levels([]PriceLevel{change.BestBid, change.BestAsk}))
+	require.Empty(t, book.TopChanges())
+}
+
+func TestOrderbookNotificationBestLevels(t *testing.T) {
+	snapshot := WSNotificationOrderbookSnapshot{
+		Ask: []WSSubtypeTrade{{Price: "0.052", Size: "2"}, {Price: "0.051", Size: "1"}},
+		Bid: []WSSubtypeTrade{{Price: "0.049", Size: "3"}, {Price: "0.050", Size: "4"}},
+	}
+	bid, ok := snapshot.BestBid()
+	require.True(t, ok)
+	require.Equal(t, "0.05", bid.Price.String())
+	ask, ok := snapshot.BestAsk()
+	require.True(t, ok)
+	require.Equal(t, "0.051", ask.Price.String())
+
+	update := WSNotificationOrderbookUpdate{
+		Ask: []WSSubtypeTrade{{Price: "0.050", Size: "0"}, {Price: "oops", Size: "1"}},
+		Bid: []WSSubtypeTrade{{Price: "0.048", Size: "1"}},
+	}
+	_, ok = update.BestAsk()
+	require.False(t, ok)
+	bid, ok = update.BestBid()
+	require.True(t, ok)
+	require.Equal(t, "0.048", bid.Price.String())
+
+	_, ok = WSNotificationOrderbookSnapshot{}.BestBid()
+	require.False(t, ok)
+}
+
+func TestOrderBookLevelOrder(t *testing.T) {
+	snapshot := WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.052", Size: "2"}, {Price: "0.051", Size: "1"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.049", Size: "3"}, {Price: "0.050", Size: "4"}},
+		Sequence: 10,
+	}
+	update := WSNotificationOrderbookUpdate{
+		Ask:      []WSSubtypeTrade{{Price: "0.052", Size: "0"}, {Price: "0.053", Size: "5"}, {Price: "0.0505", Size: "1"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.049", Size: "6"}},
+		Sequence: 11,
+	}
+
+	book := NewOrderBook("ETHBTC", WithUnsortedLevels())
+	require.NoError(t, book.ApplySnapshot(snapshot))
+	require.Equal(t, [][2]string{{"0.049", "3"}, {"0.05", "4"}}, levels(book.Bids()))
+	require.NoError(t, book.ApplyUpdate(update))
+	require.Equal(t, [][2]string{{"0.051", "1"}, {"0.053", "5"}, {"0.0505", "1"}}, levels(book.Asks()))
+	require.Equal(t, [][2]string{{"0.049", "6"}, {"0.05", "4"}}, levels(book.Bids()))
+	bid, ok := book.BestBid()
+	require.True(t, ok)
+	require.Equal(t, "0.05", bid.Price.String())
+	spread, err := book.Spread()
+	require.NoError(t, err)
+	require.Equal(t, "0.0005", spread.String())
+
+	bySize := func(a, b PriceLevel) bool { return a.Size.GreaterThan(b.Size) }
+	book = NewOrderBook("ETHBTC", WithLevelOrder(bySize, bySize))
+	require.NoError(t, book.ApplySnapshot(snapshot))
+	require.Equal(t, [][2]string{{"0.05", "4"}, {"0.049", "3"}}, levels(book.Bids()))
+	require.NoError(t, book.ApplyUpdate(update))
+	require.Equal(t, [][2]string{{"0.049", "6"}, {"0.05", "4"}}, levels(book.Bids()))
+	require.Equal(t, [][2]string{{"0.053", "5"}, {"0.051", "1"}, {"0.0505", "1"}}, levels(book.Asks()))
+	ask, ok := book.BestAsk()
+	require.True(t, ok)
+	require.Equal(t, "0.0505", ask.Price.String())
+}
+
+func TestOrderBookApplyUpdateChanges(t *testing.T) {
+	book := NewOrderBook("ETHBTC")
+	require.NoError(t, book.ApplySnapshot(WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "1"}, {Price: "0.052", Size: "2"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.050", Size: "4"}},
+		Sequence: 1,
+	}))
+
+	bids, asks, err := book.ApplyUpdateChanges(WSNotificationOrderbookUpdate{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "0"}, {Price: "0.052", Size: "2"}, {Price: "0.053", Size: "0"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.050", Size: "5"}, {Price: "0.049", Size: "1"}},
+		Sequence: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][2]string{{"0.05", "5"}, {"0.049", "1"}}, levels(bids))
+	require.Equal(t, [][2]string{{"0.051", "0"}}, levels(asks))
+	require.Equal(t, [][2]string{{"0.052", "2"}}, levels(book.Asks()))
+
+	// stale update
+	bids, asks, err = book.ApplyUpdateChanges(WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "0.040", Size: "1"}}, Sequence: 2})
+	require.NoError(t, err)
+	require.Empty(t, bids)
+	require.Empty(t, asks)
+}
+
+func TestOrderBookDiff(t *testing.T) {
+	before := NewOrderBook("ETHBTC")
+	require.NoError(t, before.ApplySnapshot(WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "1"}, {Price: "0.052", Size: "2"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.050", Size: "4"}, {Price: "0.049", Size: "3"}},
+		Sequence: 1,
+	}))
+	after := NewOrderBook("ETHBTC")
+	require.NoError(t, after.ApplySnapshot(WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.0510", Size: "1"}, {Price: "0.052", Size: "5"}, {Price: "0.053", Size: "1"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.049", Size: "3"}},
+		Sequence: 9,
+	}))
+
+	diff := before.Diff(after)
+	require.False(t, diff.Empty())
+	require.Empty(t, diff.Bids.Added)
+	require.Empty(t, diff.Bids.Changed)
+	require.Equal(t, [][2]string{{"0.05", "4"}}, levels(diff.Bids.Removed))
+	require.Equal(t, [][2]string{{"0.053", "1"}}, levels(diff.Asks.Added))
+	require.Equal(t, [][2]string{{"0.052", "5"}}, levels(diff.Asks.Changed))
+	require.Empty(t, diff.Asks.Removed)
+
+	require.True(t, after.Diff(after).Empty())
+}
+
+func TestOrderBookDeletion(t *testing.T) {
+	snapshot := WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "1"}, {Price: "0.052", Size: "2"}, {Price: "0.053", Size: "3"}, {Price: "0.054", Size: "0.001"}},
+		Sequence: 1,
+	}
+
+	book := NewOrderBook("ETHBTC")
+	require.NoError(t, book.ApplySnapshot(snapshot))
+	require.NoError(t, book.ApplyUpdate(WSNotificationOrderbookUpdate{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "0.00"}, {Price: "0.052", Size: "0E-8"}, {Price: "0.053", Size: "-0"}},
+		Sequence: 2,
+	}))
+	require.Equal(t, [][2]string{{"0.054", "0.001"}}, levels(book.Asks()))
+
+	// the dust levels are deleted as well.
+	dust := decimal.RequireFromString("0.01")
+	book = NewOrderBook("ETHBTC", WithDeletion(func(level PriceLevel) bool { return level.Size.LessThan(dust) }))
+	require.NoError(t, book.ApplySnapshot(snapshot))
+	require.Equal(t, [][2]string{{"0.051", "1"}, {"0.052", "2"}, {"0.053", "3"}}, levels(book.Asks()))
+	_, asks, err := book.ApplyUpdateChanges(WSNotificationOrderbookUpdate{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "0.005"}, {Price: "0.055", Size: "0.002"}},
+		Sequence: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][2]string{{"0.051", "0.005"}}, levels(asks))
+	require.Equal(t, [][2]string{{"0.052", "2"}, {"0.053", "3"}}, levels(book.Asks()))
+
+	// the zero levels are kept, the microprice is undefined.
+	book = NewOrderBook("ETHBTC", WithDeletion(func(PriceLevel) bool { return false }))
+	require.NoError(t, book.ApplySnapshot(WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "0"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.050", Size: "0"}},
+		Sequence: 1,
+	}))
+	require.Equal(t, [][2]string{{"0.051", "0"}}, levels(book.Asks()))
+	_, err = book.Microprice()
+	require.Equal(t, ErrEmptyOrderBook, err)
+}
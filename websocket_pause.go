@@ -0,0 +1,56 @@
+package hitbtc
+
+// Pause stops delivering the notifications to the channels of the feeds,
+// without unsubscribing them, until Resume is called. A delivery in progress
+// when Pause is called completes.
+//
+// The notifications received while paused are dropped and reported as
+// OverflowEvents, except that:
+//   - the order books of the subscriptions made with WithMaintainedOrderBook
+//     are kept current;
+//   - the order book updates of the subscriptions made with WithCoalescing are
+//     merged and the merged update delivered on Resume.
+//
+// The consumers of the other order book feeds must resynchronize after Resume,
+// e.g. by subscribing again, since the deltas received meanwhile are lost. The
+// ErrorFeed and the RawHandler are not paused.
+func (c *WSClient) Pause() {
+	c.updates.pause(true)
+}
+
+// Resume resumes the deliveries stopped by Pause.
+func (c *WSClient) Resume() {
+	c.updates.pause(false)
+	for _, s := range c.updates.subscriptions() {
+		if s.coalescer != nil {
+			s.coalescer.wake()
+		}
+	}
+}
+
+// Paused reports whether the deliveries are stopped by Pause.
+func (c *WSClient) Paused() bool {
+	return c.updates.isPaused()
+}
+
+func (h *responseChannels) pause(paused bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.paused = paused
+}
+
+func (h *responseChannels) isPaused() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.paused
+}
+
+// held reports whether the notification of the feed must not be delivered,
+// the deliveries being paused, in which case it is reported as dropped.
+func (h *responseChannels) held(kind FeedKind, symbol string, sequence int64) bool {
+	if !h.isPaused() {
+		return false
+	}
+	h.overflow(kind, symbol, sequence)
+	return true
+}
@@ -0,0 +1,307 @@
+package hitbtc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/juju/errors"
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
+)
+
+// reconnectionsSize is the number of reconnection events kept until they are
+// read, the next ones are dropped.
+const reconnectionsSize = 16
+
+// ReconnectedEvent reports a completed reconnection.
+type ReconnectedEvent struct {
+	// Downtime is the time between the loss of the previous connection, or
+	// the start of the reconnection if it was not lost, and the replay of the
+	// subscriptions. The trades and candles of this window were missed.
+	Downtime time.Duration
+	// Err is the failure to replay the subscriptions, if any.
+	Err error
+	// Attempts is the number of dials of the reconnection, 1 for Reconnect,
+	// see WithAutoReconnect.
+	Attempts int
+}
+
+// Reconnections returns the events of the completed reconnections. The events
+// are dropped while the channel is full, it is never closed.
+func (c *WSClient) Reconnections() <-chan ReconnectedEvent {
+	return c.reconnections
+}
+
+// LastError returns the last failure of the connection: ErrConnectionLost when
+// it was lost, unless hitbtc closed it normally (see NormalCloses), or the
+// failure of the last reconnection to dial or to replay the subscriptions. It
+// is cleared when a new connection is established, and nil while the
+// connection is healthy.
+func (c *WSClient) LastError() error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.lastErr
+}
+
+func (c *WSClient) setLastError(err error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.lastErr = err
+}
+
+// NormalCloseEvent reports that hitbtc closed the connection normally, with
+// the close code 1000, e.g. for a scheduled maintenance. It is not an error:
+// LastError is not set, but the connection is lost until Reconnect.
+type NormalCloseEvent struct {
+	Reason string // sent by hitbtc, possibly empty
+	At     time.Time
+}
+
+// NormalCloses returns the events of the connections closed normally by
+// hitbtc. The events are dropped while the channel is full, it is never
+// closed.
+func (c *WSClient) NormalCloses() <-chan NormalCloseEvent {
+	return c.normalCloses
+}
+
+// closeRecorder is the stream of a websocket connection, recording the close
+// frame received from hitbtc.
+type closeRecorder struct {
+	jsonrpc2.ObjectStream
+	ws *websocket.Conn // underlying the stream
+
+	mu       sync.Mutex
+	closeErr *websocket.CloseError
+}
+
+func (s *closeRecorder) ReadObject(v interface{}) error {
+	err := s.ObjectStream.ReadObject(v)
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		s.mu.Lock()
+		s.closeErr = closeErr
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// closedNormally reports whether the connection was closed normally by hitbtc,
+// with the reason of the close.
+func (s *closeRecorder) closedNormally() (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closeErr == nil || s.closeErr.Code != websocket.CloseNormalClosure {
+		return "", false
+	}
+	return s.closeErr.Text, true
+}
+
+// watch records when the connection is lost, and how.
+func (c *WSClient) watch(conn *jsonrpc2.Conn, stream *closeRecorder) {
+	<-conn.DisconnectNotify()
+	reason, normal := stream.closedNormally()
+
+	c.connMu.Lock()
+	lost := c.conn == conn && !c.closed
+	if lost {
+		c.lostAt = time.Now()
+		if !normal {
+			c.lastErr = ErrConnectionLost
+		}
+	}
+	lostAt := c.lostAt
+	reconnect := lost && c.options.reconnectMin > 0 && !c.reconnecting
+	if reconnect {
+		c.reconnecting = true
+	}
+	c.connMu.Unlock()
+
+	if reconnect {
+		go c.reconnectLoop()
+	}
+
+	if lost && normal {
+		select {
+		case c.normalCloses <- NormalCloseEvent{Reason: reason, At: lostAt}:
+		default:
+		}
+	}
+}
+
+// Reconnect replaces the connection to the hitbtc api by a new one, then logs
+// in again and replays the subscriptions on it.
+//
+// The subscriptions keep their channels, so that the consumers keep receiving
+// from the channels they already hold. As on any subscription, hitbtc sends a
+// new snapshot for the feeds having one. The orders that were filled or
+// canceled while disconnected are delivered to the reports as synthetic
+// reports.
+func (c *WSClient) Reconnect() error {
+	return c.reconnect(1)
+}
+
+// reconnect reconnects as Reconnect does, the attempts being reported in the
+// event.
+func (c *WSClient) reconnect(attempts int) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	start := time.Now()
+
+	conn, stream, err := c.dial()
+	if err != nil {
+		c.setLastError(err)
+		return errors.Annotate(err, "Hitbtc Reconnect")
+	}
+
+	c.connMu.Lock()
+	if c.closed {
+		c.connMu.Unlock()
+		conn.Close()
+		return ErrClientClosed
+	}
+	old := c.conn
+	c.conn = conn
+	c.stream = stream
+	lostAt := c.lostAt
+	c.lostAt = time.Time{}
+	c.lastErr = nil
+	c.connMu.Unlock()
+	old.Close()
+	go c.watch(conn, stream)
+	if lostAt.IsZero() {
+		lostAt = start
+	}
+
+	c.updates.state.reconnected()
+
+	err = c.restore()
+	if err != nil {
+		c.setLastError(err)
+	}
+	select {
+	case c.reconnections <- ReconnectedEvent{Downtime: time.Since(lostAt), Err: err, Attempts: attempts}:
+	default:
+	}
+	return annotate(err, "Hitbtc Reconnect")
+}
+
+// reconnectLoop reconnects until a new connection is established or the
+// client is closed, doubling the delay between the attempts from the minimum
+// to the maximum of WithAutoReconnect.
+func (c *WSClient) reconnectLoop() {
+	delay := c.options.reconnectMin
+	for attempts := 1; ; attempts++ {
+		_ = c.reconnect(attempts)
+		if c.recovered() {
+			return
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.updates.done:
+			timer.Stop()
+			c.connMu.Lock()
+			c.reconnecting = false
+			c.connMu.Unlock()
+			return
+		}
+		if delay *= 2; delay > c.options.reconnectMax {
+			delay = c.options.reconnectMax
+		}
+	}
+}
+
+// recovered reports whether the connection is up or the client closed,
+// ending the reconnection loop if so. A connection lost again meanwhile is
+// reconnected by the running loop.
+func (c *WSClient) recovered() bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.closed || c.lostAt.IsZero() {
+		c.reconnecting = false
+		return true
+	}
+	return false
+}
+
+// restore logs in again and replays the subscriptions on the current connection.
+// When the reports are subscribed, the orders that changed while disconnected
+// are then recovered as synthetic reports, see WSReport.Synthetic.
+//
+// A subscription failing is reported to its errors, the others are still
+// replayed and the first failure is returned. Each call has its own timeout,
+// so that a slow one does not fail the next ones.
+func (c *WSClient) restore() error {
+	// before the replay, which resets them with the active orders.
+	known := c.updates.orders.snapshot()
+
+	session := c.session()
+	if session != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.options.defaultTimeout)
+		err := c.login(ctx, *session)
+		cancel()
+		if err != nil {
+			return errors.Annotate(err, "login")
+		}
+	}
+
+	var first error
+	for _, s := range c.updates.subscriptions() {
+		err := c.replay(s)
+		if err == nil {
+			continue
+		}
+		err = errors.Annotatef(err, "resubscribe %s %s", s.kind, s.symbol)
+		if f := c.updates.acquire(s.kind, s.symbol); f != nil {
+			f.report(err)
+			f.inflight.Done()
+		}
+		if first == nil {
+			first = err
+		}
+	}
+
+	if session != nil && len(known) > 0 && c.updates.lookup(FeedReports, "") != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.options.timeout(readMethod))
+		err := c.recoverReports(ctx, known)
+		cancel()
+		if err != nil && first == nil {
+			first = errors.Annotate(err, "recover reports")
+		}
+	}
+	return first
+}
+
+// replay subscribes again to the feed of s, unless it was unsubscribed since
+// the replay started.
+func (c *WSClient) replay(s *Subscription) error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if c.updates.lookup(s.kind, s.symbol) != s {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.timeout(readMethod))
+	defer cancel()
+
+	var echo json.RawMessage
+	var err error
+	switch s.kind {
+	case FeedReports:
+		echo, err = c.accountSubscriptionOp(ctx, "subscribeReports")
+	case FeedBalance:
+		echo, err = c.accountSubscriptionOp(ctx, "subscribeBalance")
+	default:
+		echo, err = c.subscribeOp(s.kind, s.symbol, s.opts)
+	}
+	if err == nil {
+		s.setConfirmation(echo)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package hitbtc
+
+import (
+	"context"
+	"sync"
+)
+
+// StatusClosed is the status of the synthetic reports of the orders that are
+// no longer active after a reconnection: they were filled, canceled or expired
+// while disconnected, which getOrders does not tell.
+const StatusClosed = "closed"
+
+// openOrders are the last known states of the active orders, by client order id.
+type openOrders struct {
+	mu     sync.Mutex
+	orders map[string]WSReport
+}
+
+// reset replaces the known orders by the active orders of a snapshot.
+func (o *openOrders) reset(active []WSReport) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.orders = make(map[string]WSReport, len(active))
+	for _, r := range active {
+		o.orders[r.ClientOrderID] = r
+	}
+}
+
+// report records the state of the order of the report.
+func (o *openOrders) report(r WSReport) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.orders == nil {
+		o.orders = make(map[string]WSReport)
+	}
+	if r.OriginalRequestClientOrderID != "" {
+		delete(o.orders, r.OriginalRequestClientOrderID)
+	}
+	if r.Terminal() {
+		delete(o.orders, r.ClientOrderID)
+	} else {
+		o.orders[r.ClientOrderID] = r
+	}
+}
+
+// get returns the last known state of the order, false if it is unknown.
+func (o *openOrders) get(clientOrderID string) (WSReport, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r, ok := o.orders[clientOrderID]
+	return r, ok
+}
+
+// snapshot returns a copy of the known orders.
+func (o *openOrders) snapshot() map[string]WSReport {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	orders := make(map[string]WSReport, len(o.orders))
+	for id, r := range o.orders {
+		orders[id] = r
+	}
+	return orders
+}
+
+// recoverReports delivers a synthetic report for each of the orders known
+// before the reconnection whose state changed meanwhile: the current state of
+// the orders still active, and the last known state with StatusClosed for the
+// others. The orders whose terminal report was received since the
+// reconnection are skipped.
+func (c *WSClient) recoverReports(ctx context.Context, known map[string]WSReport) error {
+	var active []WSReport
+	if err := c.privateCall(ctx, "getOrders", struct{}{}, &active); err != nil {
+		return wsAPIError(err)
+	}
+	current := make(map[string]WSReport, len(active))
+	for _, r := range active {
+		current[r.ClientOrderID] = r
+	}
+
+	for id, last := range known {
+		r, ok := current[id]
+		switch {
+		case c.updates.fills.completed(id):
+			continue
+		case !ok:
+			r = last
+			r.Status = StatusClosed
+		case r.Status == last.Status && r.CumQuantity == last.CumQuantity:
+			continue
+		}
+		r.ReportType = ReportTypeStatus
+		r.Raw = nil
+		r.Synthetic = true
+		c.updates.deliverReport(r)
+	}
+	return nil
+}
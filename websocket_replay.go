@@ -0,0 +1,203 @@
+package hitbtc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
+)
+
+// recordedFrame is an inbound JSON-RPC frame, as written by a Recorder.
+type recordedFrame struct {
+	Time   time.Time        `json:"time"`
+	Method string           `json:"method,omitempty"` // of the request, or of the call answered
+	Notif  bool             `json:"notif,omitempty"`
+	Params *json.RawMessage `json:"params,omitempty"`
+	Result *json.RawMessage `json:"result,omitempty"`
+	Error  *jsonrpc2.Error  `json:"error,omitempty"`
+}
+
+// Recorder writes every inbound JSON-RPC frame of a client, notifications and
+// responses, as one JSON object per line. The recorded session can be fed to a
+// client with a ReplaySource.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder returns a recorder writing to w, see WithRecorder.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error that occurred writing a frame. The frames are
+// no longer recorded after an error.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// record is the receive hook of the recorded connections.
+func (r *Recorder) record(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+	frame := recordedFrame{Time: time.Now()}
+	switch {
+	case resp != nil:
+		if req != nil {
+			frame.Method = req.Method
+		}
+		frame.Result, frame.Error = resp.Result, resp.Error
+	case req != nil:
+		frame.Method, frame.Notif, frame.Params = req.Method, req.Notif, req.Params
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(frame)
+	}
+}
+
+// RecordNotification writes a notification, e.g. the method and the Raw params
+// of a value delivered with WithRawNotifications, as a frame that a
+// ReplaySource replays.
+func (r *Recorder) RecordNotification(method string, params json.RawMessage) {
+	r.record(&jsonrpc2.Request{Method: method, Params: &params, Notif: true}, nil)
+}
+
+// ReplaySource feeds a client with a session recorded by a Recorder, in place
+// of the hitbtc api, see WithReplaySource.
+//
+// The calls of the client are answered with the responses recorded for the
+// same method, in order, or with true when there is none left, so that the
+// subscriptions succeed. The notifications are only delivered by Replay.
+type ReplaySource struct {
+	notifications []recordedFrame
+
+	mu        sync.Mutex
+	responses map[string][]recordedFrame
+	handler   jsonrpc2.Handler // of the client, set when it connects
+}
+
+// NewReplaySource reads a recorded session.
+func NewReplaySource(r io.Reader) (*ReplaySource, error) {
+	s := &ReplaySource{responses: make(map[string][]recordedFrame)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var frame recordedFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, errors.Annotatef(err, "line %d", line)
+		}
+		if frame.Notif {
+			s.notifications = append(s.notifications, frame)
+		} else if frame.Result != nil || frame.Error != nil {
+			s.responses[frame.Method] = append(s.responses[frame.Method], frame)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Replay delivers the recorded notifications to the client, one at a time and
+// in order, the same way as if they were received from hitbtc. As for a live
+// connection, a notification blocks until it is read from its subscription.
+func (s *ReplaySource) Replay(ctx context.Context) error {
+	s.mu.Lock()
+	handler := s.handler
+	s.mu.Unlock()
+	if handler == nil {
+		return errors.New("replay source not connected to a client")
+	}
+
+	for _, frame := range s.notifications {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		handler.Handle(ctx, nil, &jsonrpc2.Request{Method: frame.Method, Params: frame.Params, Notif: true})
+	}
+	return nil
+}
+
+// connect returns a stream standing for a connection of the client handler.
+func (s *ReplaySource) connect(handler jsonrpc2.Handler) jsonrpc2.ObjectStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+	return &replayStream{source: s, replies: make(chan *jsonrpc2.Response, 16), closed: make(chan struct{})}
+}
+
+// reply returns the next recorded response of the method.
+func (s *ReplaySource) reply(id jsonrpc2.ID, method string) *jsonrpc2.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &jsonrpc2.Response{ID: id}
+	if recorded := s.responses[method]; len(recorded) > 0 {
+		s.responses[method] = recorded[1:]
+		resp.Result, resp.Error = recorded[0].Result, recorded[0].Error
+		return resp
+	}
+	success := json.RawMessage("true")
+	resp.Result = &success
+	return resp
+}
+
+// replayStream answers the calls of a client connected to a ReplaySource.
+type replayStream struct {
+	source  *ReplaySource
+	replies chan *jsonrpc2.Response
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// WriteObject answers the requests sent by the client.
+func (s *replayStream) WriteObject(obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	var req jsonrpc2.Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+	if req.Notif {
+		return nil
+	}
+
+	select {
+	case s.replies <- s.source.reply(req.ID, req.Method):
+		return nil
+	case <-s.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+// ReadObject returns the answers to the requests of the client.
+func (s *replayStream) ReadObject(v interface{}) error {
+	select {
+	case resp := <-s.replies:
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	case <-s.closed:
+		return io.EOF
+	}
+}
+
+func (s *replayStream) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
@@ -0,0 +1,160 @@
+package hitbtc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClientSnapshot is the state of a WSClient at a point in time, for diagnostics.
+type ClientSnapshot struct {
+	Connected bool // the current connection is up
+	Closed    bool // the client is closed
+	LoggedIn  bool // the session was logged in with Login
+
+	Subscriptions []SubscriptionInfo
+
+	// Sequences holds the last order book sequence received per symbol.
+	Sequences map[string]int64
+	// Tickers holds the last ticker received per symbol.
+	Tickers map[string]WSNotificationTickerResponse
+
+	Notifications uint64 // notifications received
+	Errors        uint64 // notifications that could not be decoded
+	Dropped       uint64 // notifications dropped by a non-blocking delivery, see OverflowFeed
+	Reconnects    uint64 // successful reconnections
+	// UnmatchedResponses counts the responses whose id matched no pending
+	// call, the calls they answered failing with ErrResponseTimeout.
+	UnmatchedResponses uint64
+}
+
+// SubscriptionInfo identifies a subscribed feed.
+type SubscriptionInfo struct {
+	Kind   FeedKind
+	Symbol string
+}
+
+// handlerState records what the handler received, for diagnostics.
+type handlerState struct {
+	mu            sync.Mutex
+	sequences     map[string]int64
+	tickers       map[string]WSNotificationTickerResponse
+	notifications uint64
+	errors        uint64
+	dropped       uint64
+	reconnects    uint64
+	unmatched     uint64
+	received      time.Time // of the last notification
+}
+
+func (s *handlerState) notification() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifications++
+	s.received = now
+}
+
+// lastReceived returns when the last notification was received.
+func (s *handlerState) lastReceived() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received
+}
+
+func (s *handlerState) error() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors++
+}
+
+func (s *handlerState) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropped++
+}
+
+func (s *handlerState) reconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconnects++
+}
+
+func (s *handlerState) unmatchedResponse() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unmatched++
+}
+
+func (s *handlerState) ticker(msg WSNotificationTickerResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tickers == nil {
+		s.tickers = make(map[string]WSNotificationTickerResponse)
+	}
+	s.tickers[msg.Symbol] = msg
+}
+
+// sequence records the sequence of an order book notification, unless an
+// older one received out of order.
+func (s *handlerState) sequence(symbol string, sequence int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sequences == nil {
+		s.sequences = make(map[string]int64)
+	}
+	if sequence > s.sequences[symbol] {
+		s.sequences[symbol] = sequence
+	}
+}
+
+// Snapshot returns the current state of the client: its connection, its
+// subscriptions, the last data received and its counters.
+func (c *WSClient) Snapshot() ClientSnapshot {
+	conn := c.connection()
+	connected := conn != nil
+	if connected {
+		select {
+		case <-conn.DisconnectNotify():
+			connected = false
+		default:
+		}
+	}
+
+	h := c.updates
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+
+	snapshot := ClientSnapshot{
+		Connected:     connected,
+		Closed:        h.closed,
+		LoggedIn:      c.session() != nil,
+		Sequences:     make(map[string]int64, len(h.state.sequences)),
+		Tickers:       make(map[string]WSNotificationTickerResponse, len(h.state.tickers)),
+		Notifications: h.state.notifications,
+		Errors:        h.state.errors,
+		Dropped:       h.state.dropped,
+		Reconnects:    h.state.reconnects,
+
+		UnmatchedResponses: h.state.unmatched,
+	}
+	for key := range h.feeds {
+		snapshot.Subscriptions = append(snapshot.Subscriptions, SubscriptionInfo{Kind: key.kind, Symbol: key.symbol})
+	}
+	sort.Slice(snapshot.Subscriptions, func(i, j int) bool {
+		a, b := snapshot.Subscriptions[i], snapshot.Subscriptions[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Symbol < b.Symbol
+	})
+	for symbol, sequence := range h.state.sequences {
+		snapshot.Sequences[symbol] = sequence
+	}
+	for symbol, ticker := range h.state.tickers {
+		snapshot.Tickers[symbol] = ticker
+	}
+	return snapshot
+}
@@ -0,0 +1,568 @@
+package hitbtc
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// FeedKind identifies the kind of data carried by a subscription.
+type FeedKind int
+
+const (
+	// FeedTicker is the ticker feed of a market.
+	FeedTicker FeedKind = iota
+	// FeedOrderbook is the order book feed of a market.
+	FeedOrderbook
+	// FeedTrades is the trades feed of a market.
+	FeedTrades
+	// FeedCandles is the candles feed of a market.
+	FeedCandles
+	// FeedReports is the execution reports feed of the account, not bound to a symbol.
+	FeedReports
+	// FeedBalance is the balance changes feed of the account, not bound to a symbol.
+	FeedBalance
+)
+
+// String returns the name of the feed kind.
+func (k FeedKind) String() string {
+	switch k {
+	case FeedTicker:
+		return "ticker"
+	case FeedOrderbook:
+		return "orderbook"
+	case FeedTrades:
+		return "trades"
+	case FeedCandles:
+		return "candles"
+	case FeedReports:
+		return "reports"
+	case FeedBalance:
+		return "balance"
+	}
+	return "unknown"
+}
+
+// feedKey identifies a single subscribed feed.
+type feedKey struct {
+	kind   FeedKind
+	symbol string
+}
+
+// Subscription contains the snapshot and update channels of a subscribed feed.
+//
+// Only the channels of the feed kind are allocated, the accessors of the other
+// kinds return nil channels.
+type Subscription struct {
+	kind   FeedKind
+	symbol string
+	opts   subOptions // replayed on reconnection
+	client *WSClient  // nil when the handler is used alone
+
+	errs chan error // errors scoped to the subscription
+
+	ticker             chan WSNotificationTickerResponse
+	orderbookSnapshots chan WSNotificationOrderbookSnapshot
+	orderbookUpdates   chan WSNotificationOrderbookUpdate
+	tradesSnapshots    chan WSNotificationTradesSnapshot
+	tradesUpdates      chan WSNotificationTradesUpdate
+	candlesSnapshots   chan WSNotificationCandlesSnapshot
+	candlesUpdates     chan WSNotificationCandlesUpdate
+	candleBars         chan CandleBar
+	activeOrders       chan []WSReport
+	reports            chan WSReport
+	balances           chan []WSBalance
+
+	// coalescer delivers the order book updates when coalescing is enabled.
+	coalescer *orderbookCoalescer
+	// book is maintained from the order book notifications when enabled.
+	book *OrderBook
+	// buffers hold the updates decoded in place with WithReusedBuffers, the
+	// next one being decoded while the other is read.
+	buffersMu  sync.Mutex
+	buffers    [2]WSNotificationOrderbookUpdate
+	nextBuffer int
+
+	confirmationMu sync.Mutex
+	confirmation   json.RawMessage // echoed by hitbtc on the last subscription
+
+	snapshotMu       sync.Mutex
+	snapshotSeen     bool
+	snapshotSequence int64 // of the last order book snapshot
+	// closing holds the in-progress candle when only the closed candles are delivered.
+	closing candleCloser
+
+	tickMu   sync.Mutex
+	tickSeen bool
+	lastTick [3]string // bid, ask and last of the last ticker received
+
+	done     chan struct{}  // closed when unsubscribing, aborts the deliveries
+	inflight sync.WaitGroup // running deliveries
+}
+
+// subscriptionErrorsSize is the number of errors of a subscription kept until
+// they are read, the next ones are dropped.
+const subscriptionErrorsSize = 16
+
+// newSubscription allocates the channels of a feed of the given kind.
+func newSubscription(kind FeedKind, symbol string, opts subOptions) *Subscription {
+	s := &Subscription{
+		kind:   kind,
+		symbol: symbol,
+		opts:   opts,
+		errs:   make(chan error, subscriptionErrorsSize),
+		done:   make(chan struct{}),
+	}
+	switch kind {
+	case FeedTicker:
+		s.ticker = make(chan WSNotificationTickerResponse)
+	case FeedOrderbook:
+		s.orderbookSnapshots = make(chan WSNotificationOrderbookSnapshot)
+		s.orderbookUpdates = make(chan WSNotificationOrderbookUpdate)
+		if opts.coalesce {
+			s.coalescer = newOrderbookCoalescer(s.orderbookUpdates)
+		}
+		if opts.maintainBook {
+			s.book = NewOrderBook(symbol)
+		}
+	case FeedTrades:
+		s.tradesSnapshots = make(chan WSNotificationTradesSnapshot)
+		s.tradesUpdates = make(chan WSNotificationTradesUpdate)
+	case FeedCandles:
+		s.candlesSnapshots = make(chan WSNotificationCandlesSnapshot)
+		s.candlesUpdates = make(chan WSNotificationCandlesUpdate)
+		if opts.candleBars {
+			s.candleBars = make(chan CandleBar)
+		}
+	case FeedReports:
+		// buffered so that a late snapshot does not block the handler forever.
+		s.activeOrders = make(chan []WSReport, 1)
+		s.reports = make(chan WSReport)
+	case FeedBalance:
+		s.balances = make(chan []WSBalance)
+	}
+	return s
+}
+
+// Kind returns the kind of the subscribed feed.
+func (s *Subscription) Kind() FeedKind { return s.kind }
+
+// Symbol returns the subscribed market.
+func (s *Subscription) Symbol() string { return s.symbol }
+
+// Ticker returns the ticker notifications of a ticker subscription.
+func (s *Subscription) Ticker() <-chan WSNotificationTickerResponse { return s.ticker }
+
+// OrderbookSnapshots returns the snapshots of an order book subscription.
+func (s *Subscription) OrderbookSnapshots() <-chan WSNotificationOrderbookSnapshot {
+	return s.orderbookSnapshots
+}
+
+// OrderbookUpdates returns the updates of an order book subscription.
+func (s *Subscription) OrderbookUpdates() <-chan WSNotificationOrderbookUpdate {
+	return s.orderbookUpdates
+}
+
+// TradesSnapshots returns the snapshots of a trades subscription.
+func (s *Subscription) TradesSnapshots() <-chan WSNotificationTradesSnapshot {
+	return s.tradesSnapshots
+}
+
+// TradesUpdates returns the updates of a trades subscription.
+func (s *Subscription) TradesUpdates() <-chan WSNotificationTradesUpdate { return s.tradesUpdates }
+
+// CandlesSnapshots returns the snapshots of a candles subscription.
+func (s *Subscription) CandlesSnapshots() <-chan WSNotificationCandlesSnapshot {
+	return s.candlesSnapshots
+}
+
+// CandlesUpdates returns the updates of a candles subscription.
+func (s *Subscription) CandlesUpdates() <-chan WSNotificationCandlesUpdate { return s.candlesUpdates }
+
+// CandleBars returns the completed bars of a candles subscription made with
+// WithCandleBars, nil otherwise.
+func (s *Subscription) CandleBars() <-chan CandleBar { return s.candleBars }
+
+// OrderBook returns the order book maintained from the notifications of an
+// order book subscription made with WithMaintainedOrderBook, nil otherwise. It
+// is updated on the connection reader and safe to read concurrently.
+func (s *Subscription) OrderBook() *OrderBook { return s.book }
+
+// ActiveOrders returns the active orders snapshots of a reports subscription.
+func (s *Subscription) ActiveOrders() <-chan []WSReport { return s.activeOrders }
+
+// Reports returns the execution reports of a reports subscription.
+func (s *Subscription) Reports() <-chan WSReport { return s.reports }
+
+// Balances returns the balance changes of a balance subscription.
+func (s *Subscription) Balances() <-chan []WSBalance { return s.balances }
+
+// Confirmation returns the result of the subscription call when hitbtc echoed
+// the subscribed channel and params instead of a bare true, nil otherwise. It
+// is updated when subscribing again on reconnection.
+func (s *Subscription) Confirmation() json.RawMessage {
+	s.confirmationMu.Lock()
+	defer s.confirmationMu.Unlock()
+	return s.confirmation
+}
+
+func (s *Subscription) setConfirmation(echo json.RawMessage) {
+	s.confirmationMu.Lock()
+	defer s.confirmationMu.Unlock()
+	s.confirmation = echo
+}
+
+// Err returns the errors scoped to the subscription: the notifications of the
+// feed that could not be decoded and the failures to subscribe again on
+// reconnection. The errors are dropped while the channel is full.
+//
+// The channel is closed when unsubscribing.
+func (s *Subscription) Err() <-chan error { return s.errs }
+
+// report delivers an error to the subscription, unless its channel is full.
+// It must only be called during a delivery.
+func (s *Subscription) report(err error) {
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+// Unsubscribe unsubscribes from the feed and closes its channels.
+func (s *Subscription) Unsubscribe() error {
+	return s.UnsubscribeContext(context.Background())
+}
+
+// UnsubscribeContext unsubscribes from the feed and closes its channels, the
+// request being bounded by ctx and by the timeout of the client. The channels
+// are closed even if the request fails or times out.
+func (s *Subscription) UnsubscribeContext(ctx context.Context) error {
+	if s.client == nil {
+		return errors.New("subscription without client")
+	}
+	_, err := s.client.unsubscribe(ctx, s.kind, s.symbol)
+	return annotate(err, "Hitbtc Unsubscribe")
+}
+
+// freshSnapshot records the sequence of an order book snapshot and reports
+// whether it differs from the previous snapshot.
+func (s *Subscription) freshSnapshot(sequence int64) bool {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if s.snapshotSeen && s.snapshotSequence == sequence {
+		return false
+	}
+	s.snapshotSeen, s.snapshotSequence = true, sequence
+	return true
+}
+
+// tickerChanged records the bid, ask and last prices of a ticker and reports
+// whether they differ from the previous ticker.
+func (s *Subscription) tickerChanged(msg WSNotificationTickerResponse) bool {
+	tick := [3]string{msg.Bid, msg.Ask, msg.Last}
+	s.tickMu.Lock()
+	defer s.tickMu.Unlock()
+	if s.tickSeen && s.lastTick == tick {
+		return false
+	}
+	s.tickSeen, s.lastTick = true, tick
+	return true
+}
+
+// close closes all the allocated channels of the subscription, once the running
+// deliveries are aborted.
+func (s *Subscription) close() {
+	close(s.done)
+	s.inflight.Wait()
+
+	if s.coalescer != nil {
+		s.coalescer.stop()
+	}
+	close(s.errs)
+	if s.ticker != nil {
+		close(s.ticker)
+	}
+	if s.orderbookSnapshots != nil {
+		close(s.orderbookSnapshots)
+	}
+	if s.orderbookUpdates != nil {
+		close(s.orderbookUpdates)
+	}
+	if s.tradesSnapshots != nil {
+		close(s.tradesSnapshots)
+	}
+	if s.tradesUpdates != nil {
+		close(s.tradesUpdates)
+	}
+	if s.candlesSnapshots != nil {
+		close(s.candlesSnapshots)
+	}
+	if s.candlesUpdates != nil {
+		close(s.candlesUpdates)
+	}
+	if s.candleBars != nil {
+		close(s.candleBars)
+	}
+	if s.activeOrders != nil {
+		close(s.activeOrders)
+	}
+	if s.reports != nil {
+		close(s.reports)
+	}
+	if s.balances != nil {
+		close(s.balances)
+	}
+}
+
+// Subscribe subscribes to the market data feed of the given kind for the symbol.
+//
+// Subscribing again to the same feed returns the existing subscription, or
+// ErrSubscriptionConflict if the options differ: unsubscribe first to change
+// them.
+// Reports and balance changes are subscribed with SubscribeReports and
+// SubscribeBalance.
+func (c *WSClient) Subscribe(kind FeedKind, symbol string, opts ...SubOption) (*Subscription, error) {
+	s, err := c.subscribe(kind, symbol, opts)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Hitbtc Subscribe %s", kind)
+	}
+	return s, nil
+}
+
+// ServerSubscriptions returns the active subscriptions of the session, one
+// "kind symbol" entry per feed, e.g. "orderbook ETHBTC", with the period of
+// the candles, e.g. "candles ETHBTC M30", and the kind alone for the account
+// feeds, sorted.
+//
+// The api v2 has no method listing the subscriptions of a session, so the
+// list is the local registry of the client: the feeds subscribed and replayed
+// on reconnection. Use the confirmation of a subscription to check that hitbtc
+// acknowledged it, see Subscription.Confirmation.
+func (c *WSClient) ServerSubscriptions() ([]string, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+
+	subs := c.updates.subscriptions()
+	entries := make([]string, 0, len(subs))
+	for _, s := range subs {
+		entry := []string{s.kind.String()}
+		if s.symbol != "" {
+			entry = append(entry, s.symbol)
+		}
+		if s.kind == FeedCandles && s.opts.period != "" {
+			entry = append(entry, s.opts.period)
+		}
+		entries = append(entries, strings.Join(entry, " "))
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+func (c *WSClient) subscribe(kind FeedKind, symbol string, opts []SubOption) (*Subscription, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	symbol = c.ResolveSymbol(symbol)
+
+	if err := c.checkKind(kind); err != nil {
+		return nil, err
+	}
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	o := newSubOptions(opts)
+	if s := c.updates.lookup(kind, symbol); s != nil && s.opts != o {
+		return nil, errors.Annotatef(ErrSubscriptionConflict, "%s %s", kind, symbol)
+	}
+	registered, err := c.registeredFeed(kind, symbol, o)
+	if err != nil {
+		return nil, err
+	}
+
+	echo, err := c.subscribeOp(kind, symbol, o)
+	if err != nil {
+		return nil, err
+	}
+
+	s := c.addFeed(kind, symbol, registered, opts)
+	s.setConfirmation(echo)
+	return s, nil
+}
+
+// RegisterChannels allocates the channels of a feed before subscribing to it,
+// so that they can be read as soon as the first notification is received. The
+// next subscription to the feed, with the same options, delivers on them.
+// See WithExplicitChannels.
+//
+// Registering the channels of a subscribed feed returns its subscription. The
+// channels are closed when the feed is unsubscribed or the client closed, and
+// when the subscription of an account feed fails: register them again to
+// subscribe again.
+func (c *WSClient) RegisterChannels(kind FeedKind, symbol string, opts ...SubOption) (*Subscription, error) {
+	if kind != FeedReports && kind != FeedBalance {
+		symbol = c.ResolveSymbol(symbol)
+	}
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	o := newSubOptions(opts)
+	key := feedKey{kind: kind, symbol: symbol}
+	s := c.updates.lookup(kind, symbol)
+	if s == nil {
+		s = c.registered[key]
+	}
+	switch {
+	case s != nil && s.opts != o:
+		return nil, errors.Annotatef(ErrSubscriptionConflict, "Hitbtc RegisterChannels %s %s", kind, symbol)
+	case s != nil:
+		return s, nil
+	}
+
+	s = newSubscription(kind, symbol, o)
+	s.client = c
+	if c.registered == nil {
+		c.registered = make(map[feedKey]*Subscription)
+	}
+	c.registered[key] = s
+	return s, nil
+}
+
+// registeredFeed returns the channels registered for a feed not subscribed
+// yet, if any. Without them it fails when WithExplicitChannels is set. c.subMu
+// must be held.
+func (c *WSClient) registeredFeed(kind FeedKind, symbol string, o subOptions) (*Subscription, error) {
+	if c.updates.lookup(kind, symbol) != nil {
+		return nil, nil
+	}
+	s := c.registered[feedKey{kind: kind, symbol: symbol}]
+	switch {
+	case s == nil && c.options.explicitChannels:
+		return nil, errors.Annotatef(ErrChannelsNotRegistered, "%s %s", kind, symbol)
+	case s != nil && s.opts != o:
+		return nil, errors.Annotatef(ErrSubscriptionConflict, "%s %s", kind, symbol)
+	}
+	return s, nil
+}
+
+// addFeed adds the feed to the subscriptions, delivering on the registered
+// channels if any. c.subMu must be held.
+func (c *WSClient) addFeed(kind FeedKind, symbol string, registered *Subscription, opts []SubOption) *Subscription {
+	if registered == nil {
+		return c.updates.subscribe(kind, symbol, opts...)
+	}
+	delete(c.registered, feedKey{kind: kind, symbol: symbol})
+	return c.updates.add(registered)
+}
+
+// closeRegistered closes the channels registered but never subscribed.
+func (c *WSClient) closeRegistered() {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for key, s := range c.registered {
+		delete(c.registered, key)
+		s.close()
+	}
+}
+
+// subscribeOp performs the server side subscription of a market data feed,
+// returning the params echoed by hitbtc if any.
+func (c *WSClient) subscribeOp(kind FeedKind, symbol string, o subOptions) (json.RawMessage, error) {
+	if kind != FeedReports && kind != FeedBalance {
+		if err := validateID("symbol", symbol); err != nil {
+			return nil, err
+		}
+	}
+	switch kind {
+	case FeedTicker:
+		return c.requestSubscriptionOp(context.Background(), "subscribeTicker", WSTickerSubscriptionRequest{Symbol: symbol, Interval: o.interval})
+	case FeedOrderbook:
+		return c.subscriptionOp(context.Background(), "subscribeOrderbook", symbol)
+	case FeedTrades:
+		return c.requestSubscriptionOp(context.Background(), "subscribeTrades", WSTradesSubscriptionRequest{Symbol: symbol, Limit: o.limit})
+	case FeedCandles:
+		return c.candlesSubscriptionOp(context.Background(), "subscribeCandles", symbol, o.period)
+	}
+	return nil, errors.NotSupportedf("subscribing to %s", kind)
+}
+
+// unsubscribe unsubscribes from the market data feed and closes its channels.
+// It reports whether the feed was subscribed, no request is sent otherwise.
+//
+// The channels are closed even if the request fails, e.g. when ctx expires on
+// a degraded connection, so that the consumers are released: hitbtc may keep
+// sending the notifications of the feed, which are dropped.
+func (c *WSClient) unsubscribe(ctx context.Context, kind FeedKind, symbol string) (bool, error) {
+	if c.isClosed() {
+		return false, ErrClientClosed
+	}
+	return c.unsubscribeFeed(ctx, kind, c.ResolveSymbol(symbol))
+}
+
+// unsubscribeFeed unsubscribes from the feed of the market id, see unsubscribe.
+func (c *WSClient) unsubscribeFeed(ctx context.Context, kind FeedKind, symbol string) (bool, error) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	s := c.updates.lookup(kind, symbol)
+	if s == nil {
+		return false, nil
+	}
+
+	var err error
+	switch kind {
+	case FeedTicker:
+		_, err = c.subscriptionOp(ctx, "unsubscribeTicker", symbol)
+	case FeedOrderbook:
+		_, err = c.subscriptionOp(ctx, "unsubscribeOrderbook", symbol)
+	case FeedTrades:
+		_, err = c.subscriptionOp(ctx, "unsubscribeTrades", symbol)
+	case FeedCandles:
+		_, err = c.candlesSubscriptionOp(ctx, "unsubscribeCandles", symbol, s.opts.period)
+	default:
+		return false, errors.NotSupportedf("unsubscribing from %s", kind)
+	}
+
+	return c.updates.unsubscribe(kind, symbol), err
+}
+
+// Unsubscribe unsubscribes from the market data feed of the given kind for the
+// symbol and closes its channels, see Subscription.UnsubscribeContext. It
+// reports whether the feed was subscribed.
+func (c *WSClient) Unsubscribe(ctx context.Context, kind FeedKind, symbol string) (bool, error) {
+	ok, err := c.unsubscribe(ctx, kind, symbol)
+	return ok, annotate(err, "Hitbtc Unsubscribe")
+}
+
+// UnsubscribeAll unsubscribes from every market data feed and closes their
+// channels, keeping the connection and the session, e.g. to replace the
+// watched markets between trading sessions. The reports and balance feeds,
+// which hitbtc cannot unsubscribe, are kept.
+//
+// Every feed is unsubscribed even if some requests fail, their channels being
+// closed anyway, and the failures are returned as an *UnsubscribeAllError.
+func (c *WSClient) UnsubscribeAll() error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	var failed []error
+	for _, s := range c.updates.subscriptions() {
+		if s.kind == FeedReports || s.kind == FeedBalance {
+			continue
+		}
+		if _, err := c.unsubscribeFeed(context.Background(), s.kind, s.symbol); err != nil {
+			failed = append(failed, &SymbolError{Symbol: s.symbol, FeedKind: s.kind, Err: err})
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Annotate(&UnsubscribeAllError{Errs: failed}, "Hitbtc UnsubscribeAll")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package hitbtc
+
+import "sync"
+
+// symbolNames remembers the symbol of the caller normalized to each market id,
+// see WithSymbolNormalizer.
+type symbolNames struct {
+	mu    sync.RWMutex
+	names map[string]string // by id
+}
+
+func (n *symbolNames) record(id, name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.names == nil {
+		n.names = make(map[string]string)
+	}
+	n.names[id] = name
+}
+
+// name returns the symbol of the caller normalized to the id, the id itself
+// if there is none.
+func (n *symbolNames) name(id string) string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if name, ok := n.names[id]; ok {
+		return name
+	}
+	return id
+}
+
+// symbolName returns the symbol of a market delivered to the consumers, see
+// WithSymbolNormalizer.
+func (h *responseChannels) symbolName(id string) string {
+	if h.client == nil || h.client.options.normalizer == nil {
+		return id
+	}
+	return h.client.symbolNames.name(id)
+}
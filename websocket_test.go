@@ -0,0 +1,2603 @@
+package hitbtc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/juju/errors"
+	jsonrpc2 "github.com/sourcegraph/jsonrpc2"
+	jsonrpc2ws "github.com/sourcegraph/jsonrpc2/websocket"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// mockReply answers a request received by the mock server.
+type mockReply func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error)
+
+// mockConnReply answers a request received by the mock server on conn.
+type mockConnReply func(conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error)
+
+// mockServer is a JSON RPC server over websocket standing for the hitbtc api.
+type mockServer struct {
+	server *httptest.Server
+	reply  mockConnReply
+
+	mu       sync.Mutex
+	requests []*jsonrpc2.Request
+	conns    []*jsonrpc2.Conn
+	sockets  []*websocket.Conn // underlying the conns
+	header   http.Header       // of the last handshake
+	closed   bool
+}
+
+// newMockServer starts a mock server answering true to every request unless reply is set.
+func newMockServer(t *testing.T, reply mockReply) *mockServer {
+	if reply == nil {
+		reply = func(*jsonrpc2.Request) (interface{}, *jsonrpc2.Error) { return true, nil }
+	}
+	return newMockConnServer(t, func(_ *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		return reply(req)
+	})
+}
+
+// newMockConnServer starts a mock server answering with reply, which is given
+// the connection of the request.
+func newMockConnServer(t *testing.T, reply mockConnReply) *mockServer {
+	s := &mockServer{reply: reply}
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{"v2"}}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the connection is registered before the client learns it is
+		// accepted, and before its requests are served, so that close and
+		// notify always find it.
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			http.Error(w, "closed", http.StatusServiceUnavailable)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			s.mu.Unlock()
+			return
+		}
+		conn := jsonrpc2.NewConn(context.Background(), jsonrpc2ws.NewObjectStream(ws), s)
+		s.conns = append(s.conns, conn)
+		s.sockets = append(s.sockets, ws)
+		s.header = r.Header
+		s.mu.Unlock()
+		<-conn.DisconnectNotify()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i := range s.conns {
+			if s.conns[i] == conn {
+				s.conns = append(s.conns[:i], s.conns[i+1:]...)
+				s.sockets = append(s.sockets[:i], s.sockets[i+1:]...)
+				break
+			}
+		}
+	}))
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *mockServer) url() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http")
+}
+
+// Handle records the requests and answers them.
+func (s *mockServer) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+	if req.Notif {
+		return
+	}
+
+	result, rpcErr := s.reply(conn, req)
+	if rpcErr != nil {
+		_ = conn.ReplyWithError(ctx, req.ID, rpcErr)
+		return
+	}
+	_ = conn.Reply(ctx, req.ID, result)
+}
+
+// calls returns the params of the received requests of the method.
+func (s *mockServer) calls(method string) []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var params []json.RawMessage
+	for _, req := range s.requests {
+		if req.Method == method && req.Params != nil {
+			params = append(params, *req.Params)
+		}
+	}
+	return params
+}
+
+// handshake returns the headers of the last handshake request.
+func (s *mockServer) handshake() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.header
+}
+
+// connections returns the number of connected clients.
+func (s *mockServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// notify sends a notification to every connected client.
+func (s *mockServer) notify(t *testing.T, method string, params interface{}) {
+	s.mu.Lock()
+	conns := append([]*jsonrpc2.Conn(nil), s.conns...)
+	s.mu.Unlock()
+	for _, conn := range conns {
+		require.NoError(t, conn.Notify(context.Background(), method, params))
+	}
+}
+
+// close closes the connections and the server, refusing the later handshakes.
+func (s *mockServer) close() {
+	s.mu.Lock()
+	s.closed = true
+	for i, conn := range s.conns {
+		conn.Close()
+		s.sockets[i].Close()
+	}
+	s.mu.Unlock()
+	s.server.Close()
+}
+
+// newTestClient connects a WSClient to the mock server.
+func newTestClient(t *testing.T, s *mockServer, opts ...Option) *WSClient {
+	client, err := NewWSClient(append([]Option{WithURL(s.url()), WithDefaultTimeout(5 * time.Second)}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return client
+}
+
+// notify feeds a fake notification to the handler as if it came from hitbtc.
+func notify(t *testing.T, h *responseChannels, method string, params interface{}) {
+	t.Helper()
+	raw, err := json.Marshal(params)
+	require.NoError(t, err)
+	msg := json.RawMessage(raw)
+	h.Handle(context.Background(), nil, &jsonrpc2.Request{Method: method, Params: &msg, Notif: true})
+}
+
+func TestWSMergeOrderbookUpdate(t *testing.T) {
+	dst := WSNotificationOrderbookUpdate{
+		Ask:      []WSSubtypeTrade{{Price: "1.0", Size: "1"}, {Price: "1.1", Size: "2"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.9", Size: "3"}},
+		Symbol:   "ETHBTC",
+		Sequence: 1,
+	}
+	mergeOrderbookUpdate(&dst, WSNotificationOrderbookUpdate{
+		Ask:      []WSSubtypeTrade{{Price: "1.1", Size: "0"}, {Price: "1.2", Size: "4"}},
+		Symbol:   "ETHBTC",
+		Sequence: 2,
+	})
+
+	require.Equal(t, []WSSubtypeTrade{{Price: "1.0", Size: "1"}, {Price: "1.1", Size: "0"}, {Price: "1.2", Size: "4"}}, dst.Ask)
+	require.Equal(t, []WSSubtypeTrade{{Price: "0.9", Size: "3"}}, dst.Bid)
+	require.Equal(t, int64(2), dst.Sequence)
+}
+
+func TestWSCoalescingDeliversLatestState(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedOrderbook, "ETHBTC", WithCoalescing())
+	defer h.closeAll()
+
+	for seq := int64(1); seq <= 3; seq++ {
+		notify(t, h, "updateOrderbook", WSNotificationOrderbookUpdate{
+			Ask:      []WSSubtypeTrade{{Price: "1.0", Size: string(rune('0' + seq))}},
+			Symbol:   "ETHBTC",
+			Sequence: seq,
+		})
+	}
+
+	// the handler never blocks, the consumer eventually gets the latest state.
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case update := <-f.orderbookUpdates:
+			if update.Sequence < 3 {
+				continue
+			}
+			require.Equal(t, []WSSubtypeTrade{{Price: "1.0", Size: "3"}}, update.Ask)
+			return
+		case <-timeout:
+			t.Fatal("no coalesced update received")
+		}
+	}
+}
+
+func TestWSOverflowFeed(t *testing.T) {
+	h := newResponseChannels()
+	h.overflows = make(chan OverflowEvent, 4)
+	f := h.subscribe(FeedOrderbook, "ETHBTC", WithCoalescing())
+	defer h.closeAll()
+
+	// the coalescer is blocked on the first update, the second one is pending.
+	for seq := int64(1); seq <= 3; seq++ {
+		notify(t, h, "updateOrderbook", WSNotificationOrderbookUpdate{Symbol: "ETHBTC", Sequence: seq})
+		if seq == 1 {
+			require.Eventually(t, func() bool {
+				f.coalescer.mu.Lock()
+				defer f.coalescer.mu.Unlock()
+				return f.coalescer.pending == nil
+			}, time.Second, time.Millisecond)
+		}
+	}
+	select {
+	case event := <-h.overflows:
+		require.Equal(t, OverflowEvent{Symbol: "ETHBTC", FeedKind: FeedOrderbook, DroppedSeq: 2}, event)
+	case <-time.After(time.Second):
+		t.Fatal("no overflow event")
+	}
+}
+
+func TestWSDuplicateSnapshot(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedOrderbook, "ETHBTC", WithMaintainedOrderBook())
+	defer h.closeAll()
+
+	snapshot := WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "1"}},
+		Symbol:   "ETHBTC",
+		Sequence: 5,
+	}
+	go func() {
+		notify(t, h, "snapshotOrderbook", snapshot)
+		notify(t, h, "updateOrderbook", WSNotificationOrderbookUpdate{Ask: []WSSubtypeTrade{{Price: "0.052", Size: "2"}}, Symbol: "ETHBTC", Sequence: 6})
+		notify(t, h, "snapshotOrderbook", snapshot)
+		notify(t, h, "updateOrderbook", WSNotificationOrderbookUpdate{Symbol: "ETHBTC", Sequence: 7})
+	}()
+
+	require.Equal(t, snapshot, <-f.orderbookSnapshots)
+	require.Equal(t, int64(6), (<-f.orderbookUpdates).Sequence)
+	// the duplicate is dropped, the book keeps the update.
+	select {
+	case <-f.orderbookSnapshots:
+		t.Fatal("duplicate snapshot delivered")
+	case update := <-f.orderbookUpdates:
+		require.Equal(t, int64(7), update.Sequence)
+	case <-time.After(time.Second):
+		t.Fatal("no update received")
+	}
+	require.Len(t, f.book.Asks(), 2)
+}
+
+func TestWSClosedCandles(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedCandles, "ETHBTC", WithClosedCandles())
+	defer h.closeAll()
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	go func() {
+		notify(t, h, "snapshotCandles", WSNotificationCandlesSnapshot{Data: []WSCandles{{Timestamp: WSTime{Time: t0}, Close: "1"}}, Symbol: "ETHBTC"})
+		notify(t, h, "updateCandles", WSNotificationCandlesUpdate{Data: WSCandles{Timestamp: WSTime{Time: t0}, Close: "2"}, Symbol: "ETHBTC"})
+		notify(t, h, "updateCandles", WSNotificationCandlesUpdate{Data: WSCandles{Timestamp: WSTime{Time: t1}, Close: "3"}, Symbol: "ETHBTC"})
+		notify(t, h, "updateCandles", WSNotificationCandlesUpdate{Data: WSCandles{Timestamp: WSTime{Time: t1}, Close: "4"}, Symbol: "ETHBTC"})
+	}()
+
+	<-f.candlesSnapshots
+	select {
+	case update := <-f.candlesUpdates:
+		require.True(t, t0.Equal(update.Data.Timestamp.Time))
+		require.Equal(t, "2", update.Data.Close)
+	case <-time.After(time.Second):
+		t.Fatal("no closed candle received")
+	}
+	select {
+	case update := <-f.candlesUpdates:
+		t.Fatalf("in-progress candle delivered: %+v", update)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWSCandleBars(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedCandles, "ETHBTC", WithPeriod("M1"), WithCandleBars())
+	defer h.closeAll()
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	candle := WSCandles{Timestamp: WSTime{Time: t0}, Open: "1", Close: "2", Min: "0.5", Max: "3", Volume: "10", VolumeQuote: "15"}
+	go func() {
+		notify(t, h, "snapshotCandles", WSNotificationCandlesSnapshot{Data: []WSCandles{{Timestamp: WSTime{Time: t0}, Open: "1", Close: "1"}}, Symbol: "ETHBTC", Period: "M1"})
+		notify(t, h, "updateCandles", WSNotificationCandlesUpdate{Data: candle, Symbol: "ETHBTC", Period: "M1"})
+		notify(t, h, "updateCandles", WSNotificationCandlesUpdate{Data: WSCandles{Timestamp: WSTime{Time: t1}, Open: "2", Close: "2"}, Symbol: "ETHBTC", Period: "M1"})
+	}()
+
+	select {
+	case bar := <-f.CandleBars():
+		require.Equal(t, "ETHBTC", bar.Symbol)
+		require.Equal(t, "M1", bar.Period)
+		require.True(t, t0.Equal(bar.Timestamp))
+		require.Equal(t, "1", bar.Open.String())
+		require.Equal(t, "3", bar.High.String())
+		require.Equal(t, "0.5", bar.Low.String())
+		require.Equal(t, "2", bar.Close.String())
+		require.Equal(t, "10", bar.Volume.String())
+		require.Equal(t, "15", bar.VolumeQuote.String())
+	case <-time.After(time.Second):
+		t.Fatal("no bar received")
+	}
+	select {
+	case bar := <-f.CandleBars():
+		t.Fatalf("in-progress bar delivered: %+v", bar)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWSSubscribeCandleBars(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	bars, err := client.SubscribeCandleBars("ETHBTC", "M1")
+	require.NoError(t, err)
+	require.Len(t, server.calls("subscribeCandles"), 1)
+
+	ok, err := client.UnsubscribeCandles("ETHBTC", "M1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, open := <-bars
+	require.False(t, open)
+}
+
+func TestTradeBarAggregator(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := NewTradeBarAggregator("ETHBTC", 7*time.Second)
+	push := func(id int, at time.Duration, price, quantity string) (CandleBar, bool) {
+		bar, closed, err := a.Push(WSTrades{ID: id, Price: price, Quantity: quantity, Timestamp: WSTime{Time: t0.Add(at)}})
+		require.NoError(t, err)
+		return bar, closed
+	}
+
+	_, closed := push(1, 0, "2", "1")
+	require.False(t, closed)
+	push(2, time.Second, "3", "2")
+	push(3, 2*time.Second, "1", "1")
+	push(2, 3*time.Second, "9", "9") // already aggregated
+	push(4, 5*time.Second, "2.5", "0.5")
+	bar, closed := push(5, 8*time.Second, "4", "1")
+	require.True(t, closed)
+
+	// the bars are aligned on the epoch, 2020-01-01 is 1s after a multiple of 7s.
+	require.True(t, t0.Add(-time.Second).Equal(bar.Timestamp), bar.Timestamp)
+	require.Equal(t, "7s", bar.Period)
+	require.Equal(t, "2", bar.Open.String())
+	require.Equal(t, "3", bar.High.String())
+	require.Equal(t, "1", bar.Low.String())
+	require.Equal(t, "2.5", bar.Close.String())
+	require.Equal(t, "4.5", bar.Volume.String())
+	require.Equal(t, "10.25", bar.VolumeQuote.String())
+
+	_, closed = push(6, time.Second, "5", "1") // late
+	require.False(t, closed)
+	_, _, err := a.Push(WSTrades{ID: 7, Price: "x", Quantity: "1"})
+	require.Error(t, err)
+
+	// the bar of the trade 5 is flushed at its end, then its trades are late.
+	_, closed = a.Flush(t0.Add(12 * time.Second))
+	require.False(t, closed)
+	bar, closed = a.Flush(t0.Add(13 * time.Second))
+	require.True(t, closed)
+	require.True(t, t0.Add(6*time.Second).Equal(bar.Timestamp), bar.Timestamp)
+	require.Equal(t, "4", bar.Close.String())
+	_, closed = a.Flush(t0.Add(time.Minute))
+	require.False(t, closed)
+	_, closed = push(8, 12*time.Second, "5", "1")
+	require.False(t, closed)
+	_, closed = a.Flush(t0.Add(time.Minute))
+	require.False(t, closed)
+}
+
+func TestWSTradeBars(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedTrades, "ETHBTC")
+	bars := make(chan CandleBar)
+	go aggregateTrades(h, f, NewTradeBarAggregator("ETHBTC", time.Minute), bars)
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	go func() {
+		notify(t, h, "snapshotTrades", WSNotificationTradesSnapshot{Symbol: "ETHBTC"})
+		notify(t, h, "updateTrades", WSNotificationTradesUpdate{Data: WSTrades{ID: 1, Price: "1", Quantity: "1", Timestamp: WSTime{Time: t0}}, Symbol: "ETHBTC"})
+		notify(t, h, "updateTrades", WSNotificationTradesUpdate{Data: WSTrades{ID: 2, Price: "2", Quantity: "1", Timestamp: WSTime{Time: t0.Add(time.Minute)}}, Symbol: "ETHBTC"})
+	}()
+
+	select {
+	case bar := <-bars:
+		require.True(t, t0.Equal(bar.Timestamp))
+		require.Equal(t, "1", bar.Close.String())
+	case <-time.After(time.Second):
+		t.Fatal("no bar received")
+	}
+
+	h.unsubscribe(FeedTrades, "ETHBTC")
+	_, open := <-bars
+	require.False(t, open)
+}
+
+func TestWSTradeBarsFlush(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedTrades, "ETHBTC")
+	bars := make(chan CandleBar)
+	go aggregateTrades(h, f, NewTradeBarAggregator("ETHBTC", 100*time.Millisecond), bars)
+	defer h.unsubscribe(FeedTrades, "ETHBTC")
+
+	// no later trade is needed to deliver the bar.
+	notify(t, h, "updateTrades", WSNotificationTradesUpdate{Data: WSTrades{ID: 1, Price: "1", Quantity: "1", Timestamp: WSTime{Time: time.Now()}}, Symbol: "ETHBTC"})
+	select {
+	case bar := <-bars:
+		require.Equal(t, "1", bar.Close.String())
+	case <-time.After(time.Second):
+		t.Fatal("no bar received")
+	}
+}
+
+func TestWSTradeBarsUnsubscribeWhileFailing(t *testing.T) {
+	raw, err := json.Marshal(WSNotificationTradesUpdate{Data: WSTrades{ID: 1, Price: "x", Quantity: "1"}, Symbol: "ETHBTC"})
+	require.NoError(t, err)
+	params := json.RawMessage(raw)
+
+	h := newResponseChannels()
+	for i := 0; i < 50; i++ {
+		f := h.subscribe(FeedTrades, "ETHBTC")
+		bars := make(chan CandleBar)
+		go aggregateTrades(h, f, NewTradeBarAggregator("ETHBTC", time.Minute), bars)
+
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				h.Handle(context.Background(), nil, &jsonrpc2.Request{Method: "updateTrades", Params: &params, Notif: true})
+			}
+		}()
+		time.Sleep(time.Millisecond)
+		h.unsubscribe(FeedTrades, "ETHBTC")
+		close(stop)
+		<-stopped
+		_, open := <-bars
+		require.False(t, open)
+	}
+}
+
+func TestWSTradesIterator(t *testing.T) {
+	trades := make([]WSTrades, 5)
+	for i := range trades {
+		trades[i] = WSTrades{ID: 5 - i, Price: "0.05", Quantity: "1", Side: "buy"}
+	}
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		var request WSGetTradesRequest
+		if err := json.Unmarshal(*req.Params, &request); err != nil {
+			return nil, &jsonrpc2.Error{Code: 10001, Message: err.Error()}
+		}
+		offset := 0
+		if request.Offset != nil {
+			offset, _ = strconv.Atoi(*request.Offset)
+		}
+		end := offset + request.Limit
+		if end > len(trades) {
+			end = len(trades)
+		}
+		return WSGetTradesResponse{Data: trades[offset:end]}, nil
+	})
+	client := newTestClient(t, server)
+
+	it := client.TradesIterator("ETHBTC", TradesIteratorOptions{Limit: 2})
+	var got []WSTrades
+	for {
+		batch, err := it.Next(context.Background())
+		if err == ErrIteratorDone {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, batch...)
+	}
+	require.Equal(t, trades, got)
+
+	// the last page is short, no further request is needed.
+	requests := server.calls("getTrades")
+	require.Len(t, requests, 3)
+	var last WSGetTradesRequest
+	require.NoError(t, json.Unmarshal(requests[2], &last))
+	require.Equal(t, "ETHBTC", last.Symbol)
+	require.Equal(t, "4", *last.Offset)
+	require.Equal(t, "DESC", last.Sort)
+	require.NotNil(t, last.Till)
+}
+
+func TestWSGetSymbolsMap(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		switch req.Method {
+		case "getSymbols":
+			return []WSGetSymbolResponse{{ID: "ETHBTC", TickSize: "0.000001"}, {ID: "BTCUSD", TickSize: "0.01"}}, nil
+		case "getCurrencies":
+			return []WSGetCurrencyResponse{{ID: "BTC", Crypto: true}}, nil
+		}
+		return nil, &jsonrpc2.Error{Code: 2001, Message: "Method not found"}
+	})
+	client := newTestClient(t, server)
+
+	symbols, err := client.GetSymbolsMap()
+	require.NoError(t, err)
+	require.Len(t, symbols, 2)
+	require.Equal(t, "0.01", symbols["BTCUSD"].TickSize)
+	require.Equal(t, "0.001", WSGetSymbolResponse{QuantityIncrement: "0.001"}.MinOrderQuantity())
+	require.Equal(t, "0.01", WSGetSymbolResponse{QuantityIncrement: "0.001", MinQuantity: "0.01"}.MinOrderQuantity())
+
+	currencies, err := client.GetCurrenciesMap()
+	require.NoError(t, err)
+	require.Equal(t, map[string]WSGetCurrencyResponse{"BTC": {ID: "BTC", Crypto: true}}, currencies)
+}
+
+func TestWSHandshakeHeader(t *testing.T) {
+	server := newMockServer(t, nil)
+	newTestClient(t, server, WithHeader("X-Api-Version", "2"), WithSubprotocols("v2"))
+
+	header := server.handshake()
+	require.Equal(t, "2", header.Get("X-Api-Version"))
+	require.Equal(t, "v2", header.Get("Sec-Websocket-Protocol"))
+}
+
+func TestWSGetTicker(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		return json.RawMessage(`{"ask":"0.051","bid":"0.05","last":"0.0505","open":"0.049","low":"0.048","high":"0.052","volume":"100","volumeQuote":"5.05","timestamp":"2020-01-01T00:00:00.000Z","symbol":"ETHBTC"}`), nil
+	})
+	client := newTestClient(t, server)
+
+	ticker, err := client.GetTicker("ETHBTC")
+	require.NoError(t, err)
+	requests := server.calls("getTicker")
+	require.Len(t, requests, 1)
+	require.JSONEq(t, `{"symbol":"ETHBTC"}`, string(requests[0]))
+	require.Equal(t, WSNotificationTickerResponse{
+		Ask:         "0.051",
+		Bid:         "0.05",
+		Last:        "0.0505",
+		Open:        "0.049",
+		Low:         "0.048",
+		High:        "0.052",
+		Volume:      "100",
+		VolumeQuote: "5.05",
+		Timestamp:   ticker.Timestamp,
+		Symbol:      "ETHBTC",
+	}, *ticker)
+	require.True(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Equal(ticker.Timestamp.Time))
+	require.Equal(t, "2020-01-01T00:00:00.000Z", ticker.Timestamp.String())
+}
+
+func TestWSGetTradesDefaults(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		return WSGetTradesResponse{}, nil
+	})
+	client := newTestClient(t, server)
+
+	_, err := client.GetTrades("ETHBTC")
+	require.NoError(t, err)
+	requests := server.calls("getTrades")
+	require.Len(t, requests, 1)
+	require.JSONEq(t, `{"symbol":"ETHBTC","limit":100,"sort":"DESC","by":"timestamp"}`, string(requests[0]))
+}
+
+func TestWSQueryTrades(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		return WSGetTradesResponse{Data: []WSTrades{{ID: 1}}}, nil
+	})
+	client := newTestClient(t, server)
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	response, err := client.QueryTrades(TradesByTimestamp("ETHBTC", from, time.Time{}).WithLimit(10).WithSort("ASC"))
+	require.NoError(t, err)
+	require.Len(t, response.Data, 1)
+	_, err = client.QueryTrades(TradesByID("ETHBTC", 100, 200).WithOffset(5))
+	require.NoError(t, err)
+
+	requests := server.calls("getTrades")
+	require.Len(t, requests, 2)
+	require.JSONEq(t, `{"symbol":"ETHBTC","limit":10,"sort":"ASC","by":"timestamp","from":"2020-01-01T00:00:00Z"}`, string(requests[0]))
+	require.JSONEq(t, `{"symbol":"ETHBTC","by":"id","from":100,"till":200,"offset":"5"}`, string(requests[1]))
+}
+
+func TestWSSubscribeTickerInterval(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	_, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	_, err = client.SubscribeTicker("BTCUSD", WithTickerInterval("3s"))
+	require.NoError(t, err)
+
+	requests := server.calls("subscribeTicker")
+	require.Len(t, requests, 2)
+	require.JSONEq(t, `{"symbol":"ETHBTC"}`, string(requests[0]))
+	require.JSONEq(t, `{"symbol":"BTCUSD","interval":"3s"}`, string(requests[1]))
+}
+
+func TestWSSubscribeConflict(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	sub, err := client.Subscribe(FeedCandles, "ETHBTC", WithPeriod("M30"))
+	require.NoError(t, err)
+	again, err := client.Subscribe(FeedCandles, "ETHBTC", WithPeriod("M30"))
+	require.NoError(t, err)
+	require.Same(t, sub, again)
+
+	_, err = client.Subscribe(FeedCandles, "ETHBTC", WithPeriod("H1"))
+	require.ErrorIs(t, err, ErrSubscriptionConflict)
+	require.Len(t, server.calls("subscribeCandles"), 2)
+}
+
+func TestWSSubscribeConfirmation(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "subscribeCandles" {
+			return map[string]interface{}{"ch": "candles", "params": req.Params}, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+
+	sub, err := client.Subscribe(FeedCandles, "ETHBTC", WithPeriod("M30"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"ch":"candles","params":{"symbol":"ETHBTC","period":"M30"}}`, string(sub.Confirmation()))
+
+	sub, err = client.Subscribe(FeedTrades, "ETHBTC")
+	require.NoError(t, err)
+	require.Nil(t, sub.Confirmation())
+}
+
+func TestWSServerSubscriptions(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	subs, err := client.ServerSubscriptions()
+	require.NoError(t, err)
+	require.Empty(t, subs)
+
+	_, err = client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	_, _, err = client.SubscribeCandles("ETHBTC", "M30")
+	require.NoError(t, err)
+	_, err = client.SubscribeBalance(context.Background())
+	require.NoError(t, err)
+
+	subs, err = client.ServerSubscriptions()
+	require.NoError(t, err)
+	require.Equal(t, []string{"balance", "candles ETHBTC M30", "ticker ETHBTC"}, subs)
+
+	client.Close()
+	_, err = client.ServerSubscriptions()
+	require.ErrorIs(t, err, ErrClientClosed)
+}
+
+func TestWSExplicitChannels(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server, WithExplicitChannels())
+
+	_, err := client.Subscribe(FeedTrades, "ETHBTC")
+	require.ErrorIs(t, err, ErrChannelsNotRegistered)
+	_, err = client.SubscribeBalance(context.Background())
+	require.ErrorIs(t, err, ErrChannelsNotRegistered)
+	require.Empty(t, server.calls("subscribeTrades"))
+
+	registered, err := client.RegisterChannels(FeedTrades, "ETHBTC")
+	require.NoError(t, err)
+	_, err = client.Subscribe(FeedTrades, "ETHBTC", WithCoalescing())
+	require.ErrorIs(t, err, ErrSubscriptionConflict)
+
+	sub, err := client.Subscribe(FeedTrades, "ETHBTC")
+	require.NoError(t, err)
+	require.Same(t, registered, sub)
+	again, err := client.RegisterChannels(FeedTrades, "ETHBTC")
+	require.NoError(t, err)
+	require.Same(t, sub, again)
+
+	server.notify(t, "updateTrades", WSNotificationTradesUpdate{Data: WSTrades{ID: 1}, Symbol: "ETHBTC"})
+	select {
+	case update := <-registered.TradesUpdates():
+		require.Equal(t, 1, update.Data.ID)
+	case <-time.After(time.Second):
+		t.Fatal("no trades update received")
+	}
+
+	// the channels registered but not subscribed are closed with the client.
+	pending, err := client.RegisterChannels(FeedTicker, "ETHBTC")
+	require.NoError(t, err)
+	client.Close()
+	_, open := <-pending.Ticker()
+	require.False(t, open)
+}
+
+func TestWSValidateIDs(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	for _, symbol := range []string{"", " ETHBTC", "ETHBTC\n", "ETH BTC", "ethbtc", "ETH\xffBTC"} {
+		_, err := client.Subscribe(FeedTicker, symbol)
+		require.ErrorContains(t, err, "not valid", "%q", symbol)
+		_, err = client.GetSymbol(symbol)
+		require.ErrorContains(t, err, "not valid", "%q", symbol)
+		_, err = client.GetCurrencyInfo(symbol)
+		require.ErrorContains(t, err, "not valid", "%q", symbol)
+	}
+	require.Empty(t, server.calls("subscribeTicker"))
+	require.Empty(t, server.calls("getSymbol"))
+	require.Empty(t, server.calls("getCurrency"))
+
+	_, err := client.Subscribe(FeedTicker, "ETHBTC")
+	require.NoError(t, err)
+}
+
+func TestWSSymbolNormalizer(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server, WithSymbolNormalizer(func(symbol string) string {
+		return strings.ReplaceAll(symbol, "-", "")
+	}))
+
+	ticker, err := client.SubscribeTicker("ETH-BTC")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"symbol":"ETHBTC"}`, string(server.calls("subscribeTicker")[0]))
+	require.Equal(t, "ETHBTC", client.ResolveSymbol("ETH-BTC"))
+
+	server.notify(t, "ticker", WSNotificationTickerResponse{Symbol: "ETHBTC", Last: "0.05"})
+	select {
+	case msg := <-ticker:
+		require.Equal(t, "ETH-BTC", msg.Symbol)
+	case <-time.After(time.Second):
+		t.Fatal("no ticker received")
+	}
+}
+
+func TestWSSubscribe(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	sub, err := client.Subscribe(FeedTrades, "ETHBTC")
+	require.NoError(t, err)
+	require.Equal(t, FeedTrades, sub.Kind())
+	require.Equal(t, "ETHBTC", sub.Symbol())
+	require.Nil(t, sub.Ticker())
+
+	server.notify(t, "updateTrades", WSNotificationTradesUpdate{Data: WSTrades{ID: 1, Price: "0.05"}, Symbol: "ETHBTC"})
+	select {
+	case update := <-sub.TradesUpdates():
+		require.Equal(t, 1, update.Data.ID)
+	case <-time.After(time.Second):
+		t.Fatal("no trades update received")
+	}
+
+	_, err = client.Subscribe(FeedReports, "")
+	require.Error(t, err)
+}
+
+func TestWSUnsubscribeContext(t *testing.T) {
+	release := make(chan struct{})
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if strings.HasPrefix(req.Method, "unsubscribe") {
+			<-release // a degraded connection
+		}
+		return true, nil
+	})
+	t.Cleanup(func() { close(release) })
+	sent := make(chan string, 8)
+	client := newTestClient(t, server, WithCallHook(func(method string, id string) { sent <- method }))
+
+	sub, err := client.Subscribe(FeedTicker, "ETHBTC")
+	require.NoError(t, err)
+	_, err = client.Subscribe(FeedTrades, "ETHBTC")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = sub.UnsubscribeContext(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, open := <-sub.Ticker()
+	require.False(t, open, "the channels were not closed")
+
+	ok, err := client.Unsubscribe(context.Background(), FeedTicker, "ETHBTC")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// a hung unsubscribe does not block Close.
+	go func() { _, _ = client.UnsubscribeTrades("ETHBTC") }()
+	for method := range sent {
+		if method == "unsubscribeTrades" {
+			break
+		}
+	}
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked")
+	}
+}
+
+func TestWSUnsubscribeReportsExisting(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	ok, err := client.UnsubscribeTrades("ETHBTC")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Empty(t, server.calls("unsubscribeTrades"))
+
+	updates, _, err := client.SubscribeTrades("ETHBTC")
+	require.NoError(t, err)
+	ok, err = client.UnsubscribeTrades("ETHBTC")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, server.calls("unsubscribeTrades"), 1)
+	_, open := <-updates
+	require.False(t, open)
+}
+
+func TestWSSnapshot(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	ticker, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	_, err = client.Subscribe(FeedOrderbook, "BTCUSD", WithCoalescing())
+	require.NoError(t, err)
+
+	server.notify(t, "ticker", WSNotificationTickerResponse{Symbol: "ETHBTC", Last: "0.05"})
+	<-ticker
+	server.notify(t, "updateOrderbook", WSNotificationOrderbookUpdate{Symbol: "BTCUSD", Sequence: 7})
+	server.notify(t, "updateOrderbook", json.RawMessage(`{"symbol":[]}`))
+	require.Eventually(t, func() bool { return client.Snapshot().Notifications == 3 }, time.Second, 10*time.Millisecond)
+	<-client.updates.ErrorFeed
+
+	snapshot := client.Snapshot()
+	require.True(t, snapshot.Connected)
+	require.False(t, snapshot.LoggedIn)
+	require.Equal(t, []SubscriptionInfo{{FeedTicker, "ETHBTC"}, {FeedOrderbook, "BTCUSD"}}, snapshot.Subscriptions)
+	require.Equal(t, map[string]int64{"BTCUSD": 7}, snapshot.Sequences)
+	require.Equal(t, "0.05", snapshot.Tickers["ETHBTC"].Last)
+	require.Equal(t, uint64(1), snapshot.Errors)
+
+	client.Close()
+	snapshot = client.Snapshot()
+	require.True(t, snapshot.Closed)
+	require.False(t, snapshot.Connected)
+	require.Empty(t, snapshot.Subscriptions)
+}
+
+func TestWSReplaceOrder(t *testing.T) {
+	previous := WSReport{ClientOrderID: "a", Price: "0.050", Quantity: "2", ReportType: ReportTypeNew}
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		var request WSReplaceOrderRequest
+		if err := json.Unmarshal(*req.Params, &request); err != nil {
+			return nil, &jsonrpc2.Error{Code: 10001, Message: err.Error()}
+		}
+		return WSReport{
+			ClientOrderID:                request.RequestClientID,
+			OriginalRequestClientOrderID: request.ClientOrderID,
+			Price:                        request.Price,
+			Quantity:                     request.Quantity,
+			ReportType:                   ReportTypeReplaced,
+		}, nil
+	})
+	client := newTestClient(t, server)
+
+	report, err := client.ReplaceOrder(context.Background(), WSReplaceOrderRequest{ClientOrderID: "a", Quantity: "1", Price: "0.05", TimeInForce: "GTC"})
+	require.NoError(t, err)
+	require.True(t, report.Replaced())
+	require.Equal(t, "a", report.OriginalRequestClientOrderID)
+	require.Len(t, report.ClientOrderID, 32)
+	require.True(t, report.KeepsQueuePosition(previous))
+
+	report, err = client.ReplaceOrder(context.Background(), WSReplaceOrderRequest{ClientOrderID: "a", Quantity: "1", Price: "0.051", TimeInForce: "GTC"})
+	require.NoError(t, err)
+	require.False(t, report.KeepsQueuePosition(previous))
+	require.False(t, previous.KeepsQueuePosition(previous))
+}
+
+func TestWSCall(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "getFuturesSymbols" {
+			return []WSGetSymbolResponse{{ID: "BTCUSD_PERP", BaseCurrency: "BTC"}}, nil
+		}
+		return nil, &jsonrpc2.Error{Code: 2001, Message: "Method not found"}
+	})
+	client := newTestClient(t, server)
+
+	var symbols []WSGetSymbolResponse
+	require.NoError(t, client.Call(context.Background(), "getFuturesSymbols", struct{}{}, &symbols))
+	require.Equal(t, []WSGetSymbolResponse{{ID: "BTCUSD_PERP", BaseCurrency: "BTC"}}, symbols)
+
+	err := client.Call(context.Background(), "getFuturesPositions", struct{}{}, nil)
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, 2001, apiErr.Code)
+
+	client.Close()
+	require.Equal(t, ErrClientClosed, client.Call(context.Background(), "getFuturesSymbols", struct{}{}, &symbols))
+}
+
+func TestWSCheckPermissions(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		switch req.Method {
+		case "getTradingBalance":
+			return []WSBalance{{Currency: "BTC", Available: "1"}}, nil
+		case "cancelOrder":
+			return nil, &jsonrpc2.Error{Code: CodeActionForbidden, Message: "Action is forbidden for this API key"}
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+
+	permissions, err := client.CheckPermissions()
+	require.NoError(t, err)
+	require.Equal(t, Permissions{TradingBalance: true}, permissions)
+	require.Len(t, server.calls("cancelOrder"), 1)
+}
+
+func TestWSCheckPermissionsUnauthenticated(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		return nil, &jsonrpc2.Error{Code: 1001, Message: "Authorization required"}
+	})
+	client := newTestClient(t, server)
+
+	_, err := client.CheckPermissions()
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, 1001, apiErr.Code)
+}
+
+func TestWSPlaceOrderRetry(t *testing.T) {
+	var mu sync.Mutex
+	var active []WSReport
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		switch req.Method {
+		case "newOrder":
+			var request WSNewOrderRequest
+			_ = json.Unmarshal(*req.Params, &request)
+			if request.Quantity == "0" {
+				return nil, &jsonrpc2.Error{Code: CodeValidationError, Message: "Validation error"}
+			}
+			report := WSReport{ClientOrderID: request.ClientOrderID, Symbol: request.Symbol, Status: "new"}
+			if request.Type == "market" {
+				// filled at once, never active, and its response is lost.
+				time.Sleep(100 * time.Millisecond)
+				report.Status = "filled"
+				return report, nil
+			}
+			mu.Lock()
+			active = append(active, report)
+			first := len(active) == 1
+			mu.Unlock()
+			if first {
+				// the response of the first order is lost.
+				time.Sleep(100 * time.Millisecond)
+			}
+			return report, nil
+		case "getOrders":
+			mu.Lock()
+			defer mu.Unlock()
+			return active, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+
+	request := WSNewOrderRequest{ClientOrderID: "a", Symbol: "ETHBTC", Side: "buy", Quantity: "1", Price: "0.05"}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.PlaceOrder(ctx, request)
+	require.Error(t, err)
+
+	report, err := client.PlaceOrder(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, "a", report.ClientOrderID)
+	require.Len(t, server.calls("newOrder"), 1)
+	require.Len(t, server.calls("getOrders"), 1)
+
+	report, err = client.PlaceOrder(context.Background(), WSNewOrderRequest{Symbol: "ETHBTC", Side: "sell", Quantity: "1", Price: "0.06"})
+	require.NoError(t, err)
+	require.Len(t, report.ClientOrderID, 32)
+	require.Len(t, server.calls("newOrder"), 2)
+	require.Len(t, server.calls("getOrders"), 1)
+
+	// a completed order is not placed again.
+	market := WSNewOrderRequest{ClientOrderID: "m", Symbol: "ETHBTC", Side: "buy", Type: "market", Quantity: "1"}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.PlaceOrder(ctx, market)
+	require.Error(t, err)
+	_, err = client.PlaceOrder(context.Background(), market)
+	require.ErrorIs(t, err, ErrOrderOutcomeUnknown)
+	require.Len(t, server.calls("newOrder"), 3)
+
+	notify(t, client.updates, "report", WSReport{ClientOrderID: "m", Status: "filled", ReportType: ReportTypeTrade})
+	report, err = client.PlaceOrder(context.Background(), market)
+	require.NoError(t, err)
+	require.Equal(t, "filled", report.Status)
+	require.Len(t, server.calls("newOrder"), 3)
+
+	// an order rejected by hitbtc may be placed again.
+	rejected := WSNewOrderRequest{ClientOrderID: "r", Symbol: "ETHBTC", Side: "buy", Quantity: "0", Price: "0.05"}
+	for i := 0; i < 2; i++ {
+		_, err = client.PlaceOrder(context.Background(), rejected)
+		require.True(t, IsValidationError(err))
+	}
+	require.Len(t, server.calls("newOrder"), 5)
+}
+
+func TestWSPlaceOrderRoundToMarket(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		switch req.Method {
+		case "getSymbol":
+			return WSGetSymbolResponse{ID: "ETHBTC", TickSize: "0.000001", QuantityIncrement: "0.001"}, nil
+		case "newOrder":
+			var request WSNewOrderRequest
+			_ = json.Unmarshal(*req.Params, &request)
+			return WSReport{ClientOrderID: request.ClientOrderID, Price: request.Price, Quantity: request.Quantity}, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+
+	report, err := client.PlaceOrder(context.Background(), WSNewOrderRequest{Symbol: "ETHBTC", Side: "buy", Quantity: "1.23456", Price: "0.0512349", RoundToMarket: true})
+	require.NoError(t, err)
+	require.Equal(t, "0.051234", report.Price)
+	require.Equal(t, "1.234", report.Quantity)
+	report, err = client.PlaceOrder(context.Background(), WSNewOrderRequest{Symbol: "ETHBTC", Side: "sell", Quantity: "2", Price: "0.0512341", RoundToMarket: true})
+	require.NoError(t, err)
+	require.Equal(t, "0.051235", report.Price)
+	require.Equal(t, "2", report.Quantity)
+	require.Len(t, server.calls("getSymbol"), 1)
+
+	_, err = client.PlaceOrder(context.Background(), WSNewOrderRequest{Symbol: "ETHBTC", Side: "buy", Quantity: "0.0001", Price: "0.05", RoundToMarket: true})
+	require.ErrorContains(t, err, "not valid")
+	require.Len(t, server.calls("newOrder"), 2)
+}
+
+func TestWSReady(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "getTradingBalance" {
+			return nil, &jsonrpc2.Error{Code: 1001, Message: "Authorization required"}
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server, WithAutoRelogin(false))
+
+	require.NoError(t, client.Ready(context.Background()))
+	require.Len(t, server.calls("getCurrency"), 1)
+
+	require.NoError(t, client.Login("key", "secret"))
+	require.Error(t, client.Ready(context.Background()))
+
+	client.Close()
+	require.Equal(t, ErrClientClosed, client.Ready(context.Background()))
+}
+
+func TestWSRecordAndReplay(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "getSymbol" {
+			return WSGetSymbolResponse{ID: "ETHBTC", TickSize: "0.000001"}, nil
+		}
+		return true, nil
+	})
+	var session bytes.Buffer
+	recorder := NewRecorder(&session)
+	client := newTestClient(t, server, WithRecorder(recorder))
+
+	_, err := client.GetSymbol("ETHBTC")
+	require.NoError(t, err)
+	updates, _, err := client.SubscribeOrderbook("ETHBTC")
+	require.NoError(t, err)
+	for seq := int64(1); seq <= 3; seq++ {
+		server.notify(t, "updateOrderbook", WSNotificationOrderbookUpdate{Symbol: "ETHBTC", Sequence: seq})
+		<-updates
+	}
+	client.Close()
+	require.NoError(t, recorder.Err())
+
+	source, err := NewReplaySource(&session)
+	require.NoError(t, err)
+	replayed, err := NewWSClient(WithReplaySource(source))
+	require.NoError(t, err)
+	defer replayed.Close()
+
+	symbol, err := replayed.GetSymbol("ETHBTC")
+	require.NoError(t, err)
+	require.Equal(t, "0.000001", symbol.TickSize)
+	updates, _, err = replayed.SubscribeOrderbook("ETHBTC")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- source.Replay(context.Background()) }()
+	for seq := int64(1); seq <= 3; seq++ {
+		require.Equal(t, seq, (<-updates).Sequence)
+	}
+	require.NoError(t, <-done)
+}
+
+func TestWSContextCancelCloses(t *testing.T) {
+	server := newMockServer(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	client := newTestClient(t, server, WithContext(ctx))
+
+	ticker, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+
+	cancel()
+	select {
+	case _, ok := <-ticker:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("ticker channel not closed")
+	}
+	_, err = client.SubscribeTicker("BTCUSD")
+	require.Equal(t, ErrClientClosed, err)
+	require.Eventually(t, func() bool { return server.connections() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWSSubscriptionErrAndUnsubscribe(t *testing.T) {
+	var failing bool
+	var mu sync.Mutex
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing && strings.Contains(string(*req.Params), "BTCUSD") {
+			return nil, &jsonrpc2.Error{Code: 2001, Message: "Symbol not found"}
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+
+	eth, err := client.Subscribe(FeedTrades, "ETHBTC")
+	require.NoError(t, err)
+	btc, err := client.Subscribe(FeedTrades, "BTCUSD")
+	require.NoError(t, err)
+
+	server.notify(t, "updateTrades", json.RawMessage(`{"symbol":"ETHBTC","data":"oops"}`))
+	require.Error(t, <-eth.Err())
+	var symbolErr *SymbolError
+	require.ErrorAs(t, <-client.updates.ErrorFeed, &symbolErr)
+	require.Equal(t, "ETHBTC", symbolErr.Symbol)
+	require.Equal(t, FeedTrades, symbolErr.FeedKind)
+
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	require.Error(t, client.Reconnect())
+	require.Contains(t, (<-btc.Err()).Error(), "BTCUSD")
+	require.Empty(t, eth.Err())
+
+	require.NoError(t, eth.Unsubscribe())
+	require.Len(t, server.calls("unsubscribeTrades"), 1)
+	_, ok := <-eth.Err()
+	require.False(t, ok)
+	_, ok = <-eth.TradesUpdates()
+	require.False(t, ok)
+}
+
+func TestWSSymbolAliases(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "getSymbol" {
+			var request WSGetSymbolRequest
+			_ = json.Unmarshal(*req.Params, &request)
+			return WSGetSymbolResponse{ID: request.Symbol}, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server, WithSymbolAliases(map[string]string{"BTCUSD": "BTCUSDT"}))
+
+	symbol, err := client.GetSymbol("BTCUSD")
+	require.NoError(t, err)
+	require.Equal(t, "BTCUSDT", symbol.ID)
+
+	sub, err := client.Subscribe(FeedTicker, "BTCUSD")
+	require.NoError(t, err)
+	require.Equal(t, "BTCUSDT", sub.Symbol())
+	require.JSONEq(t, `{"symbol":"BTCUSDT"}`, string(server.calls("subscribeTicker")[0]))
+
+	server.notify(t, "ticker", WSNotificationTickerResponse{Symbol: "BTCUSDT", Last: "1"})
+	require.Equal(t, "1", (<-sub.Ticker()).Last)
+
+	ok, err := client.UnsubscribeTicker("BTCUSD")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestWSSubscribeOrderbookWithBook(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	updates, snapshots, book, err := client.SubscribeOrderbookWithBook("ETHBTC")
+	require.NoError(t, err)
+
+	server.notify(t, "snapshotOrderbook", WSNotificationOrderbookSnapshot{
+		Ask:      []WSSubtypeTrade{{Price: "0.051", Size: "1"}},
+		Bid:      []WSSubtypeTrade{{Price: "0.050", Size: "2"}},
+		Symbol:   "ETHBTC",
+		Sequence: 1,
+	})
+	<-snapshots
+	server.notify(t, "updateOrderbook", WSNotificationOrderbookUpdate{
+		Bid:      []WSSubtypeTrade{{Price: "0.0505", Size: "3"}},
+		Symbol:   "ETHBTC",
+		Sequence: 2,
+	})
+	require.Equal(t, int64(2), (<-updates).Sequence)
+
+	require.Equal(t, int64(2), book.Sequence())
+	bid, ok := book.BestBid()
+	require.True(t, ok)
+	require.Equal(t, "0.0505", bid.Price.String())
+
+	_, _, err = client.SubscribeOrderbook("ETHUSD")
+	require.NoError(t, err)
+	_, _, _, err = client.SubscribeOrderbookWithBook("ETHUSD")
+	require.ErrorIs(t, err, ErrSubscriptionConflict)
+}
+
+func TestWSReplaceOrderExpireTime(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		return nil, &jsonrpc2.Error{Code: CodeOrderDeadlineExceeded, Message: "Internal order execution deadline exceeded"}
+	})
+	client := newTestClient(t, server)
+	ctx := context.Background()
+	expire := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	_, err := client.ReplaceOrder(ctx, WSReplaceOrderRequest{ClientOrderID: "a", TimeInForce: "GTD"})
+	require.Error(t, err)
+	_, err = client.ReplaceOrder(ctx, WSReplaceOrderRequest{ClientOrderID: "a", TimeInForce: "GTC", ExpireTime: expire})
+	require.Error(t, err)
+	// the time in force of an unknown order is left out.
+	_, err = client.ReplaceOrder(ctx, WSReplaceOrderRequest{ClientOrderID: "a", ExpireTime: expire})
+	require.ErrorContains(t, err, "replace of order a without time in force")
+	require.Empty(t, server.calls("cancelReplaceOrder"))
+
+	_, err = client.ReplaceOrder(ctx, WSReplaceOrderRequest{ClientOrderID: "a", TimeInForce: "GTD", ExpireTime: expire})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.True(t, apiErr.IsDeadlineExceeded())
+
+	var sent WSReplaceOrderRequest
+	require.NoError(t, json.Unmarshal(server.calls("cancelReplaceOrder")[0], &sent))
+	require.Equal(t, expire, sent.ExpireTime)
+
+	// the time in force and the expire time of a known order are carried.
+	at := time.Now().Add(time.Hour).Truncate(time.Millisecond).UTC()
+	server.notify(t, "report", WSReport{ClientOrderID: "b", Status: "new", ReportType: ReportTypeNew, TimeInForce: "GTD", ExpireTime: WSTime{Time: at}})
+	require.Eventually(t, func() bool {
+		_, ok := client.updates.orders.get("b")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	_, err = client.ReplaceOrder(ctx, WSReplaceOrderRequest{ClientOrderID: "b", Quantity: "1", Price: "0.05"})
+	require.ErrorAs(t, err, &apiErr)
+	require.NoError(t, json.Unmarshal(server.calls("cancelReplaceOrder")[1], &sent))
+	require.Equal(t, "GTD", sent.TimeInForce)
+	expireTime, err := time.Parse(time.RFC3339, sent.ExpireTime)
+	require.NoError(t, err)
+	require.True(t, at.Equal(expireTime), sent.ExpireTime)
+}
+
+func TestWSSubscribeBalance(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	balances, err := client.SubscribeBalance(context.Background())
+	require.NoError(t, err)
+	require.Len(t, server.calls("subscribeBalance"), 1)
+
+	server.notify(t, "balance", []WSBalance{{Currency: "BTC", Available: "1.5", Reserved: "0.5"}})
+	select {
+	case changes := <-balances:
+		require.Equal(t, []WSBalance{{Currency: "BTC", Available: "1.5", Reserved: "0.5"}}, changes)
+	case <-time.After(time.Second):
+		t.Fatal("no balance received")
+	}
+
+	require.NoError(t, client.Reconnect())
+	require.Len(t, server.calls("subscribeBalance"), 2)
+}
+
+func TestWSMustNewWSClient(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := MustNewWSClient(WithURL(server.url()))
+	client.Close()
+
+	require.Panics(t, func() { MustNewWSClient(WithURL("ws://127.0.0.1:1")) })
+}
+
+func TestWSCallHook(t *testing.T) {
+	var mu sync.Mutex
+	ids := make(map[string]string)
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server, WithCallHook(func(method, id string) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids[method] = id
+	}))
+
+	_, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	_, _, err = client.SubscribeTrades("ETHBTC")
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, ids, 2)
+	require.NotEqual(t, ids["subscribeTicker"], ids["subscribeTrades"])
+}
+
+func TestWSDefaultTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "getSymbol" {
+			<-release // never answered in time
+		}
+		return WSGetSymbolResponse{ID: "ETHBTC"}, nil
+	})
+	t.Cleanup(func() { close(release) }) // after the clients are closed
+
+	client := newTestClient(t, server, WithDefaultTimeout(50*time.Millisecond))
+	start := time.Now()
+	_, err := client.GetSymbol("ETHBTC")
+	require.ErrorIs(t, err, ErrResponseTimeout)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Less(t, time.Since(start), time.Second)
+
+	// the 30 seconds default bounds the calls when the option is not set.
+	client, err = NewWSClient(WithURL(server.url()), WithDefaultTimeout(0))
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	require.Equal(t, 30*time.Second, client.options.timeout(readMethod))
+	require.Equal(t, 30*time.Second, client.options.timeout(tradeMethod))
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetSymbol("ETHBTC")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("call failed before the default timeout: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	client.Close()
+	require.Error(t, <-done)
+}
+
+func TestWSTimeoutPerMethodClass(t *testing.T) {
+	release := make(chan struct{})
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		<-release
+		return WSReport{}, nil
+	})
+	t.Cleanup(func() { close(release) }) // after the client is closed
+	client := newTestClient(t, server, WithReadTimeout(time.Minute), WithTradeTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	_, err := client.ReplaceOrder(context.Background(), WSReplaceOrderRequest{ClientOrderID: "a", TimeInForce: "GTC"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	require.Equal(t, time.Minute, client.options.timeout(readMethod))
+	require.Equal(t, 5*time.Second, newWSOptions([]Option{WithDefaultTimeout(5 * time.Second)}).timeout(tradeMethod))
+}
+
+func TestWSDone(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+	select {
+	case <-client.Done():
+		t.Fatal("done before Close")
+	default:
+	}
+	client.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not done after Close")
+	}
+
+	// the connection is lost.
+	client = newTestClient(t, server)
+	select {
+	case <-client.Done():
+		t.Fatal("done before the connection is lost")
+	default:
+	}
+	server.close()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not done after the connection is lost")
+	}
+}
+
+func TestWSClosedClient(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+	client.Close()
+	client.Close() // closing again is harmless
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		call func() error
+	}{
+		{"GetCurrencyInfo", func() error { _, err := client.GetCurrencyInfo("BTC"); return err }},
+		{"GetCurrencies", func() error { _, err := client.GetCurrencies(); return err }},
+		{"GetCurrenciesMap", func() error { _, err := client.GetCurrenciesMap(); return err }},
+		{"GetSymbol", func() error { _, err := client.GetSymbol("ETHBTC"); return err }},
+		{"GetSymbols", func() error { _, err := client.GetSymbols(); return err }},
+		{"GetSymbolsMap", func() error { _, err := client.GetSymbolsMap(); return err }},
+		{"GetTicker", func() error { _, err := client.GetTicker("ETHBTC"); return err }},
+		{"GetTrades", func() error { _, err := client.GetTrades("ETHBTC"); return err }},
+		{"QueryTrades", func() error { _, err := client.QueryTrades(TradesByID("ETHBTC", 1, 2)); return err }},
+		{"TradesIterator", func() error {
+			_, err := client.TradesIterator("ETHBTC", TradesIteratorOptions{}).Next(ctx)
+			return err
+		}},
+		{"SubscribeTicker", func() error { _, err := client.SubscribeTicker("ETHBTC"); return err }},
+		{"UnsubscribeTicker", func() error { _, err := client.UnsubscribeTicker("ETHBTC"); return err }},
+		{"SubscribeTrades", func() error { _, _, err := client.SubscribeTrades("ETHBTC"); return err }},
+		{"UnsubscribeTrades", func() error { _, err := client.UnsubscribeTrades("ETHBTC"); return err }},
+		{"SubscribeOrderbook", func() error { _, _, err := client.SubscribeOrderbook("ETHBTC"); return err }},
+		{"SubscribeOrderbookWithBook", func() error { _, _, _, err := client.SubscribeOrderbookWithBook("ETHBTC"); return err }},
+		{"UnsubscribeOrderbook", func() error { _, err := client.UnsubscribeOrderbook("ETHBTC"); return err }},
+		{"SubscribeCandles", func() error { _, _, err := client.SubscribeCandles("ETHBTC", "M30"); return err }},
+		{"UnsubscribeCandles", func() error { _, err := client.UnsubscribeCandles("ETHBTC", "M30"); return err }},
+		{"SubscribeCandleBars", func() error { _, err := client.SubscribeCandleBars("ETHBTC", "M30"); return err }},
+		{"SubscribeTradeBars", func() error { _, err := client.SubscribeTradeBars("ETHBTC", time.Second); return err }},
+		{"Subscribe", func() error { _, err := client.Subscribe(FeedTicker, "ETHBTC"); return err }},
+		{"RegisterChannels", func() error { _, err := client.RegisterChannels(FeedTicker, "ETHBTC"); return err }},
+		{"Unsubscribe", func() error { _, err := client.Unsubscribe(ctx, FeedTicker, "ETHBTC"); return err }},
+		{"UnsubscribeAll", client.UnsubscribeAll},
+		{"ServerSubscriptions", func() error { _, err := client.ServerSubscriptions(); return err }},
+		{"Reconnect", client.Reconnect},
+		{"Login", func() error { return client.Login("key", "secret") }},
+		{"Call", func() error { return client.Call(ctx, "getSymbol", struct{}{}, &json.RawMessage{}) }},
+		{"Ready", func() error { return client.Ready(ctx) }},
+		{"CheckPermissions", func() error { _, err := client.CheckPermissions(); return err }},
+		{"SubscribeReports", func() error { _, _, err := client.SubscribeReports(ctx); return err }},
+		{"SubscribeBalance", func() error { _, err := client.SubscribeBalance(ctx); return err }},
+		{"GetTradingBalance", func() error { _, err := client.GetTradingBalance(ctx); return err }},
+		{"GetCurrencyBalance", func() error { _, err := client.GetCurrencyBalance("BTC"); return err }},
+		{"PlaceOrder", func() error {
+			_, err := client.PlaceOrder(ctx, WSNewOrderRequest{Symbol: "ETHBTC", Side: "buy", Quantity: "1", Price: "0.05"})
+			return err
+		}},
+		{"ReplaceOrder", func() error {
+			_, err := client.ReplaceOrder(ctx, WSReplaceOrderRequest{ClientOrderID: "a", TimeInForce: "GTC"})
+			return err
+		}},
+		{"WaitForFill", func() error { _, err := client.WaitForFill(ctx, "a"); return err }},
+	} {
+		require.ErrorIs(t, tc.call(), ErrClientClosed, tc.name)
+	}
+	require.Empty(t, server.calls("getSymbol"))
+}
+
+func TestWSCloseLeaks(t *testing.T) {
+	server := newMockServer(t, nil)
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newTestClient(t, server, WithContext(ctx))
+	_, err := client.Subscribe(FeedTicker, "ETHBTC")
+	require.NoError(t, err)
+	_, err = client.Subscribe(FeedOrderbook, "ETHBTC", WithCoalescing())
+	require.NoError(t, err)
+	_, err = client.Subscribe(FeedCandles, "ETHBTC", WithPeriod("M30"), WithClosedCandles())
+	require.NoError(t, err)
+	require.NoError(t, client.Reconnect())
+
+	client.Close()
+}
+
+func TestWSRawHandler(t *testing.T) {
+	type raw struct {
+		method string
+		params json.RawMessage
+	}
+	received := make(chan raw, 3)
+	h := newResponseChannels()
+	h.raw = func(method string, params json.RawMessage) {
+		received <- raw{method, params}
+	}
+
+	h.Handle(context.Background(), nil, &jsonrpc2.Request{Method: "heartbeat", Notif: true})
+	notify(t, h, "unknownMethod", map[string]string{"symbol": "ETHBTC"})
+	notify(t, h, "activeOrders", []WSReport{{ClientOrderID: "a"}})
+
+	require.Equal(t, raw{"heartbeat", nil}, <-received)
+	got := <-received
+	require.Equal(t, "unknownMethod", got.method)
+	require.JSONEq(t, `{"symbol":"ETHBTC"}`, string(got.params))
+	got = <-received
+	require.Equal(t, "activeOrders", got.method)
+	require.Contains(t, string(got.params), `"clientOrderId":"a"`)
+
+	// the active orders of a reports subscription are not raw.
+	f := h.subscribe(FeedReports, "")
+	notify(t, h, "activeOrders", []WSReport{{ClientOrderID: "b"}})
+	require.Equal(t, "b", (<-f.activeOrders)[0].ClientOrderID)
+	require.Empty(t, received)
+}
+
+func TestWSReconnectKeepsChannels(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	ticker, err := client.SubscribeTicker("ETHBTC", WithTickerInterval("1s"))
+	require.NoError(t, err)
+
+	require.NoError(t, client.Reconnect())
+	require.Eventually(t, func() bool { return server.connections() == 1 }, time.Second, 10*time.Millisecond)
+
+	requests := server.calls("subscribeTicker")
+	require.Len(t, requests, 2)
+	require.JSONEq(t, string(requests[0]), string(requests[1]))
+
+	server.notify(t, "ticker", WSNotificationTickerResponse{Symbol: "ETHBTC", Last: "0.05"})
+	select {
+	case tick := <-ticker:
+		require.Equal(t, "0.05", tick.Last)
+	case <-time.After(time.Second):
+		t.Fatal("no ticker received after reconnection")
+	}
+}
+
+func TestWSReconnectedEvent(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	// the connection is lost a while before reconnecting.
+	server.close()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection not lost")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	server = newMockServer(t, nil)
+	client.options.url = server.url()
+	require.NoError(t, client.Reconnect())
+
+	event := <-client.Reconnections()
+	require.NoError(t, event.Err)
+	require.GreaterOrEqual(t, event.Downtime, 50*time.Millisecond)
+
+	require.NoError(t, client.Reconnect())
+	event = <-client.Reconnections()
+	require.Less(t, event.Downtime, 50*time.Millisecond)
+}
+
+func TestWSUnsubscribeDuringReconnect(t *testing.T) {
+	blocked := make(chan string, 1)
+	release := make(chan struct{})
+	var calls int
+	var mu sync.Mutex
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method != "subscribeTicker" {
+			return true, nil
+		}
+		mu.Lock()
+		calls++
+		replaying := calls == 3
+		mu.Unlock()
+		if replaying {
+			var request WSTickerSubscriptionRequest
+			_ = json.Unmarshal(*req.Params, &request)
+			blocked <- request.Symbol
+			<-release
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	_, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	_, err = client.SubscribeTicker("BTCUSD")
+	require.NoError(t, err)
+
+	reconnected := make(chan error, 1)
+	go func() { reconnected <- client.Reconnect() }()
+	other := "ETHBTC"
+	if <-blocked == other {
+		other = "BTCUSD"
+	}
+	unsubscribed := make(chan error, 1)
+	go func() {
+		_, err := client.UnsubscribeTicker(other)
+		unsubscribed <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	require.NoError(t, <-reconnected)
+	require.NoError(t, <-unsubscribed)
+
+	// the feed is either not replayed, or unsubscribed after its replay.
+	require.Nil(t, client.updates.lookup(FeedTicker, other))
+	var last string
+	server.mu.Lock()
+	for _, req := range server.requests {
+		if req.Params != nil && strings.Contains(string(*req.Params), other) {
+			last = req.Method
+		}
+	}
+	server.mu.Unlock()
+	require.Equal(t, "unsubscribeTicker", last)
+}
+
+func TestWSLastError(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+	require.NoError(t, client.LastError())
+
+	server.close()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection not lost")
+	}
+	require.Eventually(t, func() bool { return client.LastError() == ErrConnectionLost }, time.Second, 10*time.Millisecond)
+
+	require.Error(t, client.Reconnect())
+	require.Error(t, client.LastError())
+	require.NotEqual(t, ErrConnectionLost, client.LastError())
+
+	server = newMockServer(t, nil)
+	client.options.url = server.url()
+	require.NoError(t, client.Reconnect())
+	require.NoError(t, client.LastError())
+
+	client.Close()
+	require.NoError(t, client.LastError())
+}
+
+func TestWSNormalClose(t *testing.T) {
+	closeCode := make(chan int, 2)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		code := <-closeCode
+		_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, "maintenance"))
+		_, _, _ = ws.ReadMessage()
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	client, err := NewWSClient(WithURL(url))
+	require.NoError(t, err)
+	defer client.Close()
+	closeCode <- websocket.CloseNormalClosure
+	select {
+	case event := <-client.NormalCloses():
+		require.Equal(t, "maintenance", event.Reason)
+	case <-time.After(time.Second):
+		t.Fatal("no normal close event")
+	}
+	require.NoError(t, client.LastError())
+
+	require.NoError(t, client.Reconnect())
+	closeCode <- websocket.CloseInternalServerErr
+	require.Eventually(t, func() bool { return client.LastError() == ErrConnectionLost }, time.Second, 10*time.Millisecond)
+	require.Empty(t, client.NormalCloses())
+}
+
+func TestWSCloseWhileNotified(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	ticker, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	updates, _, err := client.SubscribeOrderbook("ETHBTC")
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			server.mu.Lock()
+			conns := append([]*jsonrpc2.Conn(nil), server.conns...)
+			server.mu.Unlock()
+			for _, conn := range conns {
+				_ = conn.Notify(context.Background(), "ticker", WSNotificationTickerResponse{Symbol: "ETHBTC"})
+				_ = conn.Notify(context.Background(), "updateOrderbook", WSNotificationOrderbookUpdate{Symbol: "ETHBTC", Sequence: int64(i)})
+				_ = conn.Notify(context.Background(), "updateTrades", "malformed")
+			}
+		}
+	}()
+
+	// only the ticker is consumed, the order book and error deliveries are blocked.
+	for i := 0; i < 10; i++ {
+		<-ticker
+	}
+	client.Close()
+
+	for range ticker {
+	}
+	for range updates {
+	}
+	_, err = client.Subscribe(FeedTicker, "BTCUSD")
+	require.ErrorIs(t, err, ErrClientClosed)
+	_, err = client.UnsubscribeTicker("ETHBTC")
+	require.Equal(t, ErrClientClosed, err)
+}
+
+func TestWSGetCurrencyBalance(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "getTradingBalance" {
+			return []WSBalance{{Currency: "BTC", Available: "1", Reserved: "0.5"}, {Currency: "ETH", Available: "2"}}, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+
+	balance, err := client.GetCurrencyBalance("ETH")
+	require.NoError(t, err)
+	require.Equal(t, &WSBalance{Currency: "ETH", Available: "2"}, balance)
+
+	balance, err = client.GetCurrencyBalance("BTC")
+	require.NoError(t, err)
+	require.Equal(t, "0.5", balance.Reserved)
+	require.Len(t, server.calls("getTradingBalance"), 1)
+
+	_, err = client.GetCurrencyBalance("XRP")
+	require.True(t, errors.IsNotFound(err))
+
+	_, err = client.GetCurrencyBalance("btc")
+	require.True(t, errors.IsNotValid(err))
+
+	balances, err := client.GetTradingBalance(context.Background())
+	require.NoError(t, err)
+	require.Len(t, balances, 2)
+	require.Len(t, server.calls("getTradingBalance"), 2)
+}
+
+func TestWSPause(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		return true, nil
+	})
+	client := newTestClient(t, server, WithOverflowFeed(4))
+	ticker, err := client.Subscribe(FeedTicker, "ETHBTC")
+	require.NoError(t, err)
+	book, err := client.Subscribe(FeedOrderbook, "ETHBTC", WithCoalescing(), WithMaintainedOrderBook())
+	require.NoError(t, err)
+	h := client.updates
+
+	client.Pause()
+	require.True(t, client.Paused())
+	notify(t, h, "snapshotOrderbook", WSNotificationOrderbookSnapshot{Symbol: "ETHBTC", Sequence: 1})
+	// not read while paused, the deliveries do not block.
+	notify(t, h, "ticker", WSNotificationTickerResponse{Symbol: "ETHBTC"})
+	for seq := int64(2); seq <= 3; seq++ {
+		notify(t, h, "updateOrderbook", WSNotificationOrderbookUpdate{
+			Ask:      []WSSubtypeTrade{{Price: "1.0", Size: strconv.FormatInt(seq, 10)}},
+			Symbol:   "ETHBTC",
+			Sequence: seq,
+		})
+	}
+	require.Equal(t, OverflowEvent{Symbol: "ETHBTC", FeedKind: FeedOrderbook, DroppedSeq: 1}, <-client.OverflowFeed())
+	require.Equal(t, OverflowEvent{Symbol: "ETHBTC", FeedKind: FeedTicker}, <-client.OverflowFeed())
+	require.Equal(t, int64(3), book.OrderBook().Sequence())
+	select {
+	case <-book.OrderbookUpdates():
+		t.Fatal("update delivered while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.Resume()
+	require.False(t, client.Paused())
+	select {
+	case update := <-book.OrderbookUpdates():
+		require.Equal(t, int64(3), update.Sequence)
+		require.Equal(t, []WSSubtypeTrade{{Price: "1.0", Size: "3"}}, update.Ask)
+	case <-time.After(time.Second):
+		t.Fatal("no coalesced update after Resume")
+	}
+
+	go notify(t, h, "ticker", WSNotificationTickerResponse{Symbol: "ETHBTC", Last: "1"})
+	require.Equal(t, "1", (<-ticker.Ticker()).Last)
+}
+
+func TestWSOrderCircuitBreaker(t *testing.T) {
+	var mu sync.Mutex
+	failing := true
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Method == "newOrder" && failing {
+			return nil, &jsonrpc2.Error{Code: 20001, Message: "Insufficient funds"}
+		}
+		return WSReport{Status: "new"}, nil
+	})
+	const coolDown = 50 * time.Millisecond
+	client := newTestClient(t, server, WithOrderCircuitBreaker(2, coolDown))
+	require.NoError(t, client.Login("key", "secret"))
+	place := func() error {
+		_, err := client.PlaceOrder(context.Background(), WSNewOrderRequest{Symbol: "ETHBTC", Side: "buy", Quantity: "1", Price: "0.05"})
+		return err
+	}
+
+	require.Equal(t, CircuitClosed, client.CircuitState())
+	var apiErr *APIError
+	require.ErrorAs(t, place(), &apiErr)
+	require.Equal(t, CircuitClosed, client.CircuitState())
+	require.ErrorAs(t, place(), &apiErr)
+	require.Equal(t, CircuitOpen, client.CircuitState())
+	require.ErrorIs(t, place(), ErrCircuitOpen)
+	require.Len(t, server.calls("newOrder"), 2)
+
+	// the probe fails, the circuit opens again.
+	time.Sleep(coolDown)
+	require.Equal(t, CircuitHalfOpen, client.CircuitState())
+	require.ErrorAs(t, place(), &apiErr)
+	require.Equal(t, CircuitOpen, client.CircuitState())
+	require.Len(t, server.calls("newOrder"), 3)
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	time.Sleep(coolDown)
+	require.NoError(t, place())
+	require.Equal(t, CircuitClosed, client.CircuitState())
+}
+
+func TestWSSubscribeReports(t *testing.T) {
+	server := newMockConnServer(t, func(conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "subscribeReports" {
+			_ = conn.Notify(context.Background(), "activeOrders", []WSReport{{ClientOrderID: "a", Status: "new", ReportType: ReportTypeStatus}})
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+
+	active, reports, err := client.SubscribeReports(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []WSReport{{ClientOrderID: "a", Status: "new", ReportType: ReportTypeStatus}}, active)
+	require.Len(t, server.calls("subscribeReports"), 1)
+
+	server.notify(t, "report", WSReport{ClientOrderID: "a", Status: "partiallyFilled", ReportType: ReportTypeTrade, TradeQuantity: "0.5"})
+	select {
+	case report := <-reports:
+		require.Equal(t, "a", report.ClientOrderID)
+		require.Equal(t, ReportTypeTrade, report.ReportType)
+		require.Equal(t, "0.5", report.TradeQuantity)
+	case <-time.After(time.Second):
+		t.Fatal("no report received")
+	}
+}
+
+func TestWSSubscribeReportsFillsOnly(t *testing.T) {
+	server := newMockConnServer(t, func(conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "subscribeReports" {
+			_ = conn.Notify(context.Background(), "activeOrders", []WSReport{{ClientOrderID: "a", ReportType: ReportTypeStatus}})
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+
+	active, reports, err := client.SubscribeReports(context.Background(), WithFillsOnly())
+	require.NoError(t, err)
+	require.Len(t, active, 1)
+
+	h := client.updates
+	notify(t, h, "report", WSReport{ClientOrderID: "a", ReportType: ReportTypeNew})
+	notify(t, h, "report", WSReport{ClientOrderID: "a", ReportType: ReportTypeCanceled})
+	go notify(t, h, "report", WSReport{ClientOrderID: "a", ReportType: ReportTypeTrade, TradeQuantity: "1"})
+	require.Equal(t, "1", (<-reports).TradeQuantity)
+
+	_, _, err = client.SubscribeReports(context.Background())
+	require.ErrorIs(t, err, ErrSubscriptionConflict)
+}
+
+func TestWSResponseTimeout(t *testing.T) {
+	server := newMockConnServer(t, func(conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method != "getSymbol" {
+			return true, nil
+		}
+		// the response carries an unknown id, the right one comes too late.
+		_ = conn.Reply(context.Background(), jsonrpc2.ID{Num: req.ID.Num + 1000}, WSGetSymbolResponse{ID: "ETHBTC"})
+		time.Sleep(100 * time.Millisecond)
+		return WSGetSymbolResponse{ID: "ETHBTC"}, nil
+	})
+	client := newTestClient(t, server, WithReadTimeout(50*time.Millisecond))
+
+	_, err := client.GetSymbol("ETHBTC")
+	require.ErrorIs(t, err, ErrResponseTimeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.False(t, IsAPIError(err))
+	require.Eventually(t, func() bool { return client.Snapshot().UnmatchedResponses == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWSRemoteAddr(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	require.Equal(t, server.server.Listener.Addr().String(), client.RemoteAddr().String())
+	require.Nil(t, client.TLSState()) // ws:// url
+
+	require.NoError(t, client.Reconnect())
+	require.Equal(t, server.server.Listener.Addr().String(), client.RemoteAddr().String())
+}
+
+func TestWSSubscribeTradesLimit(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+
+	_, _, err := client.SubscribeTrades("ETHBTC", WithTradesLimit(500))
+	require.NoError(t, err)
+	_, _, err = client.SubscribeTrades("BTCUSD", WithTradesLimit(0))
+	require.NoError(t, err)
+
+	calls := server.calls("subscribeTrades")
+	require.Len(t, calls, 2)
+	require.JSONEq(t, `{"symbol":"ETHBTC","limit":500}`, string(calls[0]))
+	require.JSONEq(t, `{"symbol":"BTCUSD"}`, string(calls[1])) // the server default
+}
+
+func TestWSLivenessReadiness(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+	require.True(t, client.Liveness())
+	require.True(t, client.Readiness())
+
+	ticker, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	require.False(t, client.Readiness()) // no ticker received yet
+	server.notify(t, "ticker", WSNotificationTickerResponse{Symbol: "ETHBTC"})
+	<-ticker
+	require.True(t, client.Readiness())
+
+	server.mu.Lock()
+	server.conns[0].Close()
+	server.mu.Unlock()
+	require.Eventually(t, func() bool { return !client.Readiness() }, time.Second, 10*time.Millisecond)
+	require.True(t, client.Liveness()) // until reconnected
+
+	client.Close()
+	require.False(t, client.Liveness())
+	require.False(t, client.Readiness())
+}
+
+func TestWSUnsubscribeAll(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		if req.Method == "unsubscribeTrades" {
+			return nil, &jsonrpc2.Error{Code: 2001, Message: "Symbol not found"}
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+
+	ticker, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	book, err := client.Subscribe(FeedOrderbook, "BTCUSD")
+	require.NoError(t, err)
+	trades, _, err := client.SubscribeTrades("XRPUSDT")
+	require.NoError(t, err)
+
+	err = client.UnsubscribeAll()
+	var unsubErr *UnsubscribeAllError
+	require.ErrorAs(t, err, &unsubErr)
+	require.Len(t, unsubErr.Errs, 1)
+	var symbolErr *SymbolError
+	require.ErrorAs(t, unsubErr.Errs[0], &symbolErr)
+	require.Equal(t, FeedTrades, symbolErr.FeedKind)
+	require.Equal(t, "XRPUSDT", symbolErr.Symbol)
+
+	for _, method := range []string{"unsubscribeTicker", "unsubscribeOrderbook", "unsubscribeTrades"} {
+		require.Len(t, server.calls(method), 1, method)
+	}
+	_, open := <-ticker
+	require.False(t, open)
+	_, open = <-book.OrderbookUpdates()
+	require.False(t, open)
+	_, open = <-trades
+	require.False(t, open)
+	subs, err := client.ServerSubscriptions()
+	require.NoError(t, err)
+	require.Empty(t, subs)
+
+	require.NoError(t, client.UnsubscribeAll())
+}
+
+func TestWSRawNotifications(t *testing.T) {
+	h := newResponseChannels()
+	h.keepRaw = true
+	f := h.subscribe(FeedTicker, "ETHBTC")
+	defer h.closeAll()
+
+	raw := json.RawMessage(`{"symbol": "ETHBTC", "last": "0.050", "extra": 1}`)
+	go h.Handle(context.Background(), nil, &jsonrpc2.Request{Method: "ticker", Params: &raw, Notif: true})
+	ticker := <-f.ticker
+	require.Equal(t, "0.050", ticker.Last)
+	require.Equal(t, string(raw), string(ticker.Raw))
+
+	var session bytes.Buffer
+	recorder := NewRecorder(&session)
+	recorder.RecordNotification("ticker", ticker.Raw)
+	require.NoError(t, recorder.Err())
+
+	source, err := NewReplaySource(&session)
+	require.NoError(t, err)
+	replayed, err := NewWSClient(WithReplaySource(source))
+	require.NoError(t, err)
+	defer replayed.Close()
+	tickers, err := replayed.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+	go func() { _ = source.Replay(context.Background()) }()
+	replayedTicker := <-tickers
+	require.Equal(t, "0.050", replayedTicker.Last)
+	require.Nil(t, replayedTicker.Raw) // not kept by default
+}
+
+func TestWSTickerChangesOnly(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedTicker, "ETHBTC", WithTickerChangesOnly())
+	defer h.closeAll()
+
+	tick := WSNotificationTickerResponse{Symbol: "ETHBTC", Bid: "0.049", Ask: "0.051", Last: "0.050", Volume: "10"}
+	go notify(t, h, "ticker", tick)
+	require.Equal(t, "10", (<-f.ticker).Volume)
+
+	tick.Volume = "11" // not a meaningful change: skipped without blocking
+	notify(t, h, "ticker", tick)
+	h.state.mu.Lock()
+	require.Equal(t, "11", h.state.tickers["ETHBTC"].Volume)
+	h.state.mu.Unlock()
+
+	tick.Last = "0.0505"
+	go notify(t, h, "ticker", tick)
+	require.Equal(t, "0.0505", (<-f.ticker).Last)
+}
+
+func TestWSWaitForFill(t *testing.T) {
+	server := newMockConnServer(t, func(conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		switch req.Method {
+		case "subscribeReports":
+			_ = conn.Notify(context.Background(), "activeOrders", []WSReport{})
+		case "getOrders":
+			return []WSReport{{ClientOrderID: "a", Status: "new"}, {ClientOrderID: "d", Status: "new"}}, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+
+	_, err := client.WaitForFill(context.Background(), "a")
+	require.True(t, errors.IsNotFound(err))
+
+	_, reports, err := client.SubscribeReports(context.Background())
+	require.NoError(t, err)
+	go func() {
+		for range reports {
+		}
+	}()
+
+	h := client.updates
+	notify(t, h, "report", WSReport{ClientOrderID: "b", Status: "canceled", ReportType: ReportTypeCanceled})
+	report, err := client.WaitForFill(context.Background(), "b")
+	require.NoError(t, err)
+	require.Equal(t, "canceled", report.Status) // completed before the call
+
+	_, err = client.WaitForFill(context.Background(), "c")
+	require.True(t, errors.IsNotFound(err))
+
+	filled := make(chan *WSReport)
+	failed := make(chan error, 1)
+	go func() {
+		report, err := client.WaitForFill(context.Background(), "a")
+		if err != nil {
+			failed <- err
+			return
+		}
+		filled <- report
+	}()
+	require.Eventually(t, func() bool { return len(server.calls("getOrders")) == 2 }, time.Second, time.Millisecond)
+	notify(t, h, "report", WSReport{ClientOrderID: "a", Status: "partiallyFilled", ReportType: ReportTypeTrade, TradeQuantity: "1"})
+	notify(t, h, "report", WSReport{ClientOrderID: "a", Status: "filled", ReportType: ReportTypeTrade, TradeQuantity: "2"})
+	select {
+	case report = <-filled:
+	case err := <-failed:
+		t.Fatal(err)
+	}
+	require.Equal(t, "filled", report.Status)
+	require.Equal(t, "2", report.TradeQuantity)
+
+	_, err = client.WaitForFill(context.Background(), "a")
+	require.NoError(t, err) // kept once completed
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.WaitForFill(ctx, "d")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWSReconnectRecoversReports(t *testing.T) {
+	server := newMockConnServer(t, func(conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		switch req.Method {
+		case "subscribeReports":
+			_ = conn.Notify(context.Background(), "activeOrders", []WSReport{})
+		case "getOrders":
+			return []WSReport{
+				{ClientOrderID: "a", Status: "partiallyFilled", CumQuantity: "1"},
+				{ClientOrderID: "c", Status: "new", CumQuantity: "0"},
+			}, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+	_, reports, err := client.SubscribeReports(context.Background())
+	require.NoError(t, err)
+
+	h := client.updates
+	h.orders.reset([]WSReport{
+		{ClientOrderID: "a", Status: "new", CumQuantity: "0"},
+		{ClientOrderID: "b", Status: "new", CumQuantity: "0", Symbol: "ETHBTC"},
+		{ClientOrderID: "c", Status: "new", CumQuantity: "0"},
+		{ClientOrderID: "d", Status: "new", CumQuantity: "0"},
+	})
+	h.fills.report(WSReport{ClientOrderID: "d", Status: "filled"}) // received since the reconnection
+
+	reconnected := make(chan error)
+	go func() { reconnected <- client.Reconnect() }()
+	recovered := map[string]WSReport{}
+	for len(recovered) < 2 {
+		r := <-reports
+		require.True(t, r.Synthetic)
+		require.Equal(t, ReportTypeStatus, r.ReportType)
+		recovered[r.ClientOrderID] = r
+	}
+	require.NoError(t, <-reconnected)
+	require.Equal(t, "1", recovered["a"].CumQuantity)
+	require.Equal(t, StatusClosed, recovered["b"].Status)
+	require.Equal(t, "ETHBTC", recovered["b"].Symbol)
+
+	report, err := client.WaitForFill(context.Background(), "b")
+	require.NoError(t, err)
+	require.True(t, report.Synthetic)
+}
+
+func TestWSReconnectTimeoutPerCall(t *testing.T) {
+	var mu sync.Mutex
+	var slow bool
+	server := newMockConnServer(t, func(conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		mu.Lock()
+		delay := slow
+		mu.Unlock()
+		if delay {
+			time.Sleep(60 * time.Millisecond)
+		}
+		if req.Method == "subscribeReports" {
+			_ = conn.Notify(context.Background(), "activeOrders", []WSReport{})
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server, WithDefaultTimeout(100*time.Millisecond))
+	require.NoError(t, client.Login("key", "secret"))
+	_, _, err := client.SubscribeReports(context.Background())
+	require.NoError(t, err)
+	_, err = client.SubscribeBalance(context.Background())
+	require.NoError(t, err)
+
+	// the login and the replays take longer than one timeout together.
+	mu.Lock()
+	slow = true
+	mu.Unlock()
+	require.NoError(t, client.Reconnect())
+	require.Len(t, server.calls("login"), 2)
+	require.Len(t, server.calls("subscribeReports"), 2)
+	require.Len(t, server.calls("subscribeBalance"), 2)
+}
+
+func TestWSCredentialsRotation(t *testing.T) {
+	var mu sync.Mutex
+	var key string
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.Method {
+		case "login":
+			var login WSLoginRequest
+			require.NoError(t, json.Unmarshal(*req.Params, &login))
+			key = login.PKey
+			return true, nil
+		case "getTradingBalance":
+			if key == "k1" {
+				return nil, &jsonrpc2.Error{Code: 429, Message: "Too many requests"}
+			}
+			return []WSBalance{{Currency: "BTC", Available: "1"}}, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server, WithCredentials([]Credential{{"k1", "s1"}, {"k2", "s2"}}))
+	require.Len(t, server.calls("login"), 1)
+	require.True(t, client.Snapshot().LoggedIn)
+
+	// the calls failing together rotate the key once.
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			var balances []WSBalance
+			err := client.Call(context.Background(), "getTradingBalance", struct{}{}, &balances)
+			if err == nil && len(balances) != 1 {
+				err = errors.Errorf("%d balances", len(balances))
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(t, <-errs)
+	}
+	require.Len(t, server.calls("login"), 2)
+	require.Equal(t, "k2", client.session().apiKey)
+
+	// a single key is not rotated.
+	single := newTestClient(t, server, WithCredentials([]Credential{{"k1", "s1"}}))
+	err := single.Call(context.Background(), "getTradingBalance", struct{}{}, &json.RawMessage{})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, 429, apiErr.Code)
+	require.Len(t, server.calls("login"), 3)
+}
+
+func TestWSLogin(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		var login WSLoginRequest
+		require.NoError(t, json.Unmarshal(*req.Params, &login))
+		switch login.PKey {
+		case "unknown":
+			return nil, &jsonrpc2.Error{Code: 1002, Message: "Authorization failed"}
+		case "forbidden":
+			return nil, &jsonrpc2.Error{Code: CodeActionForbidden, Message: "Action is forbidden for this API key"}
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+
+	require.NoError(t, client.Login("key", "secret"))
+	require.JSONEq(t, `{"algo":"BASIC","pKey":"key","sKey":"secret"}`, string(server.calls("login")[0]))
+	require.True(t, client.Snapshot().LoggedIn)
+
+	for _, tc := range []struct {
+		key  string
+		code int
+	}{{"unknown", 1002}, {"forbidden", CodeActionForbidden}} {
+		err := client.Login(tc.key, "secret")
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr, tc.key)
+		require.Equal(t, tc.code, apiErr.Code)
+		require.True(t, apiErr.IsAuth())
+		require.Contains(t, err.Error(), "Hitbtc Login")
+	}
+
+	hmacClient := newTestClient(t, server, WithHMACLogin())
+	require.NoError(t, hmacClient.Login("key", "secret"))
+	calls := server.calls("login")
+	var login WSLoginRequest
+	require.NoError(t, json.Unmarshal(calls[len(calls)-1], &login))
+	require.Equal(t, LoginHS256, login.Algo)
+	require.Empty(t, login.SKey)
+	require.Len(t, login.Nonce, 32)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(login.Nonce))
+	require.Equal(t, hex.EncodeToString(mac.Sum(nil)), login.Signature)
+}
+
+func TestWSAutoRelogin(t *testing.T) {
+	var mu sync.Mutex
+	var expired int
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Method == "getTradingBalance" {
+			if expired > 0 {
+				expired--
+				return nil, &jsonrpc2.Error{Code: 1001, Message: "Authorization required"}
+			}
+			return []WSBalance{{Currency: "BTC", Available: "1"}}, nil
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+	require.NoError(t, client.Login("key", "secret"))
+
+	// the session is logged in again and the call retried.
+	mu.Lock()
+	expired = 1
+	mu.Unlock()
+	balances, err := client.GetTradingBalance(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []WSBalance{{Currency: "BTC", Available: "1"}}, balances)
+	require.Len(t, server.calls("login"), 2)
+	require.Len(t, server.calls("getTradingBalance"), 2)
+
+	// the retry is not repeated.
+	mu.Lock()
+	expired = 2
+	mu.Unlock()
+	_, err = client.GetTradingBalance(context.Background())
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, 1001, apiErr.Code)
+	require.Len(t, server.calls("login"), 3)
+	require.Len(t, server.calls("getTradingBalance"), 4)
+}
+
+func TestWSAutoReconnect(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server, WithAutoReconnect(10*time.Millisecond, 50*time.Millisecond))
+	tickers, err := client.SubscribeTicker("ETHBTC")
+	require.NoError(t, err)
+
+	// drop the connection on the server side.
+	server.mu.Lock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.mu.Unlock()
+
+	select {
+	case event := <-client.Reconnections():
+		require.NoError(t, event.Err)
+		require.Equal(t, 1, event.Attempts)
+	case <-time.After(time.Second):
+		t.Fatal("not reconnected")
+	}
+	require.Len(t, server.calls("subscribeTicker"), 2)
+	require.NoError(t, client.LastError())
+
+	server.notify(t, "ticker", WSNotificationTickerResponse{Symbol: "ETHBTC", Last: "0.05"})
+	select {
+	case ticker := <-tickers:
+		require.Equal(t, "0.05", ticker.Last)
+	case <-time.After(time.Second):
+		t.Fatal("no ticker after the reconnection")
+	}
+}
+
+func TestWSAutoReconnectBackoff(t *testing.T) {
+	server := newMockServer(t, nil)
+	var mu sync.Mutex
+	handshakes := 0
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		handshakes++
+		refused := handshakes == 2 || handshakes == 3
+		mu.Unlock()
+		if refused {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		server.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer unavailable.Close()
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	client, err := NewWSClient(WithURL("ws"+strings.TrimPrefix(unavailable.URL, "http")), WithAutoReconnect(10*time.Millisecond, 20*time.Millisecond))
+	require.NoError(t, err)
+	server.mu.Lock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.mu.Unlock()
+
+	select {
+	case event := <-client.Reconnections():
+		require.NoError(t, event.Err)
+		require.Equal(t, 3, event.Attempts)
+		require.GreaterOrEqual(t, event.Downtime, 30*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("not reconnected")
+	}
+	require.NoError(t, client.LastError())
+	client.Close()
+}
+
+func TestWSConcurrentSubscriptions(t *testing.T) {
+	server := newMockServer(t, nil)
+	client := newTestClient(t, server)
+	h := client.updates
+	symbols := []string{"ETHBTC", "BTCUSD"}
+
+	// each subscription is read until unsubscribed, which closes its channels.
+	cycles := []func(symbol string) error{
+		func(symbol string) error {
+			tickers, err := client.SubscribeTicker(symbol)
+			if err != nil {
+				return err
+			}
+			go func() {
+				for range tickers {
+				}
+			}()
+			_, err = client.UnsubscribeTicker(symbol)
+			return err
+		},
+		func(symbol string) error {
+			updates, snapshots, err := client.SubscribeTrades(symbol)
+			if err != nil {
+				return err
+			}
+			go func() {
+				for range updates {
+				}
+			}()
+			go func() {
+				for range snapshots {
+				}
+			}()
+			_, err = client.UnsubscribeTrades(symbol)
+			return err
+		},
+		func(symbol string) error {
+			updates, snapshots, err := client.SubscribeOrderbook(symbol)
+			if err != nil {
+				return err
+			}
+			go func() {
+				for range updates {
+				}
+			}()
+			go func() {
+				for range snapshots {
+				}
+			}()
+			_, err = client.UnsubscribeOrderbook(symbol)
+			return err
+		},
+		func(symbol string) error {
+			updates, snapshots, err := client.SubscribeCandles(symbol, "M30")
+			if err != nil {
+				return err
+			}
+			go func() {
+				for range updates {
+				}
+			}()
+			go func() {
+				for range snapshots {
+				}
+			}()
+			_, err = client.UnsubscribeCandles(symbol, "M30")
+			return err
+		},
+	}
+
+	stop := make(chan struct{})
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		for sequence := int64(1); ; sequence++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			symbol := symbols[sequence%2]
+			notify(t, h, "ticker", WSNotificationTickerResponse{Symbol: symbol, Last: "0.05"})
+			notify(t, h, "updateTrades", WSNotificationTradesUpdate{Symbol: symbol, Data: WSTrades{ID: int(sequence)}})
+			notify(t, h, "snapshotOrderbook", WSNotificationOrderbookSnapshot{Symbol: symbol, Sequence: sequence})
+			notify(t, h, "updateCandles", WSNotificationCandlesUpdate{Symbol: symbol, Period: "M30", Data: WSCandles{Close: "0.05"}})
+			time.Sleep(100 * time.Microsecond) // leaves the subscribers some time on a single CPU
+		}
+	}()
+
+	errs := make(chan error, len(cycles)*len(symbols))
+	for _, cycle := range cycles {
+		for _, symbol := range symbols {
+			go func(cycle func(string) error, symbol string) {
+				var err error
+				for i := 0; i < 20 && err == nil; i++ {
+					err = cycle(symbol)
+				}
+				errs <- err
+			}(cycle, symbol)
+		}
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(t, <-errs)
+	}
+
+	client.Close() // while notifications are still handled
+	close(stop)
+	<-fed
+}
+
+func TestWSUnsubscribeNotSubscribed(t *testing.T) {
+	server := newMockServer(t, func(req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+		var params struct{ Symbol string }
+		require.NoError(t, json.Unmarshal(*req.Params, &params))
+		if params.Symbol == "UNKNOWN" {
+			return nil, &jsonrpc2.Error{Code: 2001, Message: "Symbol not found"}
+		}
+		return true, nil
+	})
+	client := newTestClient(t, server)
+
+	for _, feed := range []struct {
+		method      string
+		subscribe   func(symbol string) error
+		unsubscribe func(symbol string) (bool, error)
+	}{
+		{"unsubscribeTicker", func(symbol string) error {
+			_, err := client.SubscribeTicker(symbol)
+			return err
+		}, client.UnsubscribeTicker},
+		{"unsubscribeTrades", func(symbol string) error {
+			_, _, err := client.SubscribeTrades(symbol)
+			return err
+		}, client.UnsubscribeTrades},
+		{"unsubscribeOrderbook", func(symbol string) error {
+			_, _, err := client.SubscribeOrderbook(symbol)
+			return err
+		}, client.UnsubscribeOrderbook},
+		{"unsubscribeCandles", func(symbol string) error {
+			_, _, err := client.SubscribeCandles(symbol, "M30")
+			return err
+		}, func(symbol string) (bool, error) { return client.UnsubscribeCandles(symbol, "M30") }},
+	} {
+		// before subscribing, and after a failed subscription: nothing is sent.
+		ok, err := feed.unsubscribe("ETHBTC")
+		require.NoError(t, err, feed.method)
+		require.False(t, ok, feed.method)
+		require.Error(t, feed.subscribe("UNKNOWN"), feed.method)
+		ok, err = feed.unsubscribe("UNKNOWN")
+		require.NoError(t, err, feed.method)
+		require.False(t, ok, feed.method)
+		require.Empty(t, server.calls(feed.method))
+
+		require.NoError(t, feed.subscribe("ETHBTC"), feed.method)
+		ok, err = feed.unsubscribe("ETHBTC")
+		require.NoError(t, err, feed.method)
+		require.True(t, ok, feed.method)
+		ok, err = feed.unsubscribe("ETHBTC")
+		require.NoError(t, err, feed.method)
+		require.False(t, ok, feed.method)
+		require.Len(t, server.calls(feed.method), 1)
+	}
+}
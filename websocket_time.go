@@ -0,0 +1,53 @@
+package hitbtc
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// wsTimeLayout is the format of the timestamps sent by hitbtc.
+const wsTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// WSTime is a timestamp of a websocket message, sent by hitbtc in the ISO 8601
+// format with fractional seconds, e.g. "2017-10-19T15:45:44.941Z". An empty or
+// null timestamp is decoded as the zero time.
+type WSTime struct {
+	time.Time
+
+	raw string // as received, empty for a timestamp built in code
+}
+
+// String returns the timestamp as received from hitbtc. A timestamp built in
+// code is formatted in the hitbtc format, empty for the zero time.
+func (t WSTime) String() string {
+	if t.raw != "" {
+		return t.raw
+	}
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(wsTimeLayout)
+}
+
+func (t WSTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t *WSTime) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.NotValidf("timestamp %s", data)
+	}
+	if s == nil || *s == "" {
+		*t = WSTime{}
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, *s)
+	if err != nil {
+		return errors.NotValidf("timestamp %q", *s)
+	}
+	*t = WSTime{Time: parsed, raw: *s}
+	return nil
+}
@@ -0,0 +1,55 @@
+package hitbtc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWSTimeJSON(t *testing.T) {
+	var trade WSTrades
+	require.NoError(t, json.Unmarshal([]byte(`{"id":1,"timestamp":"2017-10-19T15:45:44.941Z"}`), &trade))
+	require.True(t, trade.Timestamp.Equal(time.Date(2017, 10, 19, 15, 45, 44, 941e6, time.UTC)))
+	require.Equal(t, "2017-10-19T15:45:44.941Z", trade.Timestamp.String())
+
+	raw, err := json.Marshal(trade.Timestamp)
+	require.NoError(t, err)
+	require.Equal(t, `"2017-10-19T15:45:44.941Z"`, string(raw))
+
+	var report WSReport
+	require.NoError(t, json.Unmarshal([]byte(`{"createdAt":"2017-10-19T15:45:44Z","expireTime":""}`), &report))
+	require.Equal(t, "2017-10-19T15:45:44Z", report.CreatedAt.String())
+	require.True(t, report.ExpireTime.IsZero())
+	require.Equal(t, "", report.UpdatedAt.String())
+
+	// the precision and the offset received are kept.
+	require.NoError(t, json.Unmarshal([]byte(`{"timestamp":"2017-10-19T18:45:44.941123+03:00"}`), &trade))
+	require.True(t, trade.Timestamp.Equal(time.Date(2017, 10, 19, 15, 45, 44, 941123e3, time.UTC)))
+	require.Equal(t, "2017-10-19T18:45:44.941123+03:00", trade.Timestamp.String())
+	raw, err = json.Marshal(trade.Timestamp)
+	require.NoError(t, err)
+	require.Equal(t, `"2017-10-19T18:45:44.941123+03:00"`, string(raw))
+	require.NoError(t, json.Unmarshal([]byte(`{"timestamp":null}`), &trade))
+	require.Equal(t, "", trade.Timestamp.String())
+
+	// the timestamps built in code are formatted.
+	built := WSTime{Time: time.Date(2017, 10, 19, 18, 45, 44, 941123e3, time.FixedZone("MSK", 3*3600))}
+	require.Equal(t, "2017-10-19T15:45:44.941Z", built.String())
+
+	require.Error(t, json.Unmarshal([]byte(`{"timestamp":"19/10/2017"}`), &trade))
+	require.Error(t, json.Unmarshal([]byte(`{"timestamp":1508427944}`), &trade))
+}
+
+func TestWSTimeMalformedNotification(t *testing.T) {
+	h := newResponseChannels()
+	f := h.subscribe(FeedTicker, "ETHBTC")
+	defer h.closeAll()
+
+	go notify(t, h, "ticker", json.RawMessage(`{"symbol":"ETHBTC","timestamp":"yesterday"}`))
+	var symbolErr *SymbolError
+	require.ErrorAs(t, <-h.ErrorFeed, &symbolErr)
+	require.Equal(t, "ETHBTC", symbolErr.Symbol)
+	require.Error(t, <-f.Err())
+}
@@ -0,0 +1,232 @@
+package hitbtc
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/shopspring/decimal"
+)
+
+// TradeSide is the side of the taker of a trade.
+type TradeSide int
+
+const (
+	// TradeSideUnknown is the side of a trade with a missing or unexpected side.
+	TradeSideUnknown TradeSide = iota
+	// TradeSideBuy is the side of a trade whose taker bought.
+	TradeSideBuy
+	// TradeSideSell is the side of a trade whose taker sold.
+	TradeSideSell
+)
+
+// String returns the side as sent by hitbtc.
+func (s TradeSide) String() string {
+	switch s {
+	case TradeSideBuy:
+		return "buy"
+	case TradeSideSell:
+		return "sell"
+	}
+	return "unknown"
+}
+
+// TakerSide returns the parsed taker side of the trade. It is not named Side
+// since the trade already has a Side field.
+func (t WSTrades) TakerSide() TradeSide {
+	return parseTradeSide(t.Side)
+}
+
+// parseTradeSide parses a side as sent by hitbtc.
+func parseTradeSide(side string) TradeSide {
+	switch side {
+	case "buy":
+		return TradeSideBuy
+	case "sell":
+		return TradeSideSell
+	}
+	return TradeSideUnknown
+}
+
+// PriceDecimal returns the price of the trade as an exact decimal.
+func (t WSTrades) PriceDecimal() (decimal.Decimal, error) {
+	return parseDecimal("price", t.Price)
+}
+
+// QuantityDecimal returns the quantity of the trade as an exact decimal.
+func (t WSTrades) QuantityDecimal() (decimal.Decimal, error) {
+	return parseDecimal("quantity", t.Quantity)
+}
+
+// The defaults of the getTrades requests, applied by the client rather than
+// left to hitbtc.
+const (
+	// DefaultTradesLimit is the number of trades per request, at most 1000.
+	DefaultTradesLimit = 100
+	// SortDesc sorts the trades from the newest, SortAsc from the oldest.
+	SortDesc = "DESC"
+	SortAsc  = "ASC"
+	// ByTimestamp ranges the trades by timestamp, ByID by trade id.
+	ByTimestamp = "timestamp"
+	ByID        = "id"
+)
+
+const maxTradesPageSize = 1000
+
+// TradesIteratorOptions filters the trades returned by a TradesIterator.
+type TradesIteratorOptions struct {
+	Limit int        // Trades per batch, 100 by default and at most 1000
+	Sort  string     // SortDesc (default) or SortAsc
+	From  *time.Time // Oldest trade timestamp, optional
+	Till  *time.Time // Newest trade timestamp, the creation of the iterator by default
+}
+
+// TradesIterator pages through the trades history of a market.
+type TradesIterator struct {
+	client  *WSClient
+	request WSGetTradesRequest
+	offset  int
+	done    bool
+}
+
+// TradesIterator returns an iterator over the trades history of the market.
+//
+// The time range is fixed when the iterator is created, so that trades made
+// while iterating do not shift the pages.
+func (c *WSClient) TradesIterator(symbol string, opts TradesIteratorOptions) *TradesIterator {
+	symbol = c.ResolveSymbol(symbol)
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultTradesLimit
+	}
+	if opts.Limit > maxTradesPageSize {
+		opts.Limit = maxTradesPageSize
+	}
+	if opts.Sort == "" {
+		opts.Sort = SortDesc
+	}
+	if opts.Till == nil {
+		now := time.Now().UTC()
+		opts.Till = &now
+	}
+
+	return &TradesIterator{
+		client: c,
+		request: WSGetTradesRequest{
+			Symbol: symbol,
+			Limit:  opts.Limit,
+			Sort:   opts.Sort,
+			By:     ByTimestamp,
+			From:   opts.From,
+			Till:   opts.Till,
+		},
+	}
+}
+
+// Next returns the next batch of trades, or ErrIteratorDone once the trades are exhausted.
+func (it *TradesIterator) Next(ctx context.Context) ([]WSTrades, error) {
+	if it.done {
+		return nil, ErrIteratorDone
+	}
+	if err := validateID("symbol", it.request.Symbol); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc TradesIterator")
+	}
+
+	request := it.request
+	offset := strconv.Itoa(it.offset)
+	request.Offset = &offset
+
+	var response WSGetTradesResponse
+	err := it.client.rpc(ctx, "getTrades", request, &response)
+	if err != nil {
+		return nil, errors.Annotate(err, "Hitbtc TradesIterator")
+	}
+
+	it.offset += len(response.Data)
+	if len(response.Data) < request.Limit {
+		it.done = true
+	}
+	if len(response.Data) == 0 {
+		return nil, ErrIteratorDone
+	}
+	return response.Data, nil
+}
+
+// TradesQuery is a getTrades request paginated by timestamp or by trade id,
+// built by TradesByTimestamp or TradesByID so that its range matches its mode.
+type TradesQuery struct {
+	request wsTradesQueryRequest
+}
+
+// wsTradesQueryRequest is the getTrades request of a TradesQuery, its range
+// being timestamps or trade ids depending on By.
+type wsTradesQueryRequest struct {
+	Symbol string      `json:"symbol"`
+	Limit  int         `json:"limit,omitempty"`
+	Sort   string      `json:"sort,omitempty"`
+	By     string      `json:"by"`
+	From   interface{} `json:"from,omitempty"`
+	Till   interface{} `json:"till,omitempty"`
+	Offset *string     `json:"offset,omitempty"`
+}
+
+// TradesByTimestamp queries the trades of the market made in the time range,
+// a zero time leaving its bound open.
+func TradesByTimestamp(symbol string, from, till time.Time) TradesQuery {
+	q := TradesQuery{request: wsTradesQueryRequest{Symbol: symbol, By: ByTimestamp}}
+	if !from.IsZero() {
+		q.request.From = from.UTC()
+	}
+	if !till.IsZero() {
+		q.request.Till = till.UTC()
+	}
+	return q
+}
+
+// TradesByID queries the trades of the market in the trade id range, a zero
+// id leaving its bound open.
+func TradesByID(symbol string, from, till int64) TradesQuery {
+	q := TradesQuery{request: wsTradesQueryRequest{Symbol: symbol, By: ByID}}
+	if from != 0 {
+		q.request.From = from
+	}
+	if till != 0 {
+		q.request.Till = till
+	}
+	return q
+}
+
+// WithLimit returns the query limited to n trades, at most 1000.
+func (q TradesQuery) WithLimit(n int) TradesQuery {
+	q.request.Limit = n
+	return q
+}
+
+// WithSort returns the query sorted in the direction, SortDesc or SortAsc.
+func (q TradesQuery) WithSort(sort string) TradesQuery {
+	q.request.Sort = sort
+	return q
+}
+
+// WithOffset returns the query skipping the first offset trades.
+func (q TradesQuery) WithOffset(offset int) TradesQuery {
+	s := strconv.Itoa(offset)
+	q.request.Offset = &s
+	return q
+}
+
+// QueryTrades obtains the trades matching the query.
+func (c *WSClient) QueryTrades(q TradesQuery) (*WSGetTradesResponse, error) {
+	request := q.request
+	request.Symbol = c.ResolveSymbol(request.Symbol)
+	if err := validateID("symbol", request.Symbol); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc QueryTrades")
+	}
+	var response WSGetTradesResponse
+
+	err := c.call("getTrades", request, &response)
+	if err != nil {
+		return nil, errors.Annotate(err, "Hitbtc QueryTrades")
+	}
+	return &response, nil
+}
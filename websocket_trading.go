@@ -0,0 +1,560 @@
+package hitbtc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Report types of the execution reports.
+const (
+	ReportTypeStatus    = "status"
+	ReportTypeNew       = "new"
+	ReportTypeCanceled  = "canceled"
+	ReportTypeExpired   = "expired"
+	ReportTypeSuspended = "suspended"
+	ReportTypeTrade     = "trade"
+	ReportTypeReplaced  = "replaced"
+)
+
+// WSReport is an order execution report received on websocket
+type WSReport struct {
+	ID                           string `json:"id"`
+	ClientOrderID                string `json:"clientOrderId"`
+	Symbol                       string `json:"symbol"`
+	Side                         string `json:"side"`
+	Status                       string `json:"status"` // new, suspended, partiallyFilled, filled, canceled, expired
+	Type                         string `json:"type"`
+	TimeInForce                  string `json:"timeInForce"`
+	Quantity                     string `json:"quantity"`
+	Price                        string `json:"price"`
+	CumQuantity                  string `json:"cumQuantity"`
+	PostOnly                     bool   `json:"postOnly"`
+	CreatedAt                    WSTime `json:"createdAt"`
+	UpdatedAt                    WSTime `json:"updatedAt"`
+	StopPrice                    string `json:"stopPrice,omitempty"`
+	ExpireTime                   WSTime `json:"expireTime"` // zero unless the time in force is GTD
+	ReportType                   string `json:"reportType"` // status, new, canceled, expired, suspended, trade, replaced
+	TradeQuantity                string `json:"tradeQuantity,omitempty"`
+	TradePrice                   string `json:"tradePrice,omitempty"`
+	TradeID                      int64  `json:"tradeId,omitempty"`
+	TradeFee                     string `json:"tradeFee,omitempty"`
+	OriginalRequestClientOrderID string `json:"originalRequestClientOrderId,omitempty"`
+
+	// Raw holds the params of the report notification as received, see
+	// WithRawNotifications, nil for the reports of the calls.
+	Raw json.RawMessage `json:"-"`
+	// Synthetic reports that the report was built by the client on
+	// reconnection for an order that changed meanwhile, see Reconnect.
+	Synthetic bool `json:"-"`
+}
+
+// SubscribeReports subscribes to the execution reports of the account orders.
+//
+// The active orders sent by hitbtc right after the subscription are returned as
+// the initial snapshot, followed by the channel of live reports. The session
+// must be authenticated.
+//
+// The active orders that hitbtc may send after login, before subscribing, are
+// only delivered to the RawHandler, see WithRawHandler.
+//
+// The reports can be restricted to the fills with WithFillsOnly. Subscribing
+// again with other options returns ErrSubscriptionConflict: unsubscribe first
+// to change them.
+func (c *WSClient) SubscribeReports(ctx context.Context, opts ...SubOption) ([]WSReport, <-chan WSReport, error) {
+	if c.isClosed() {
+		return nil, nil, ErrClientClosed
+	}
+
+	if err := c.checkKind(FeedReports); err != nil {
+		return nil, nil, errors.Annotate(err, "Hitbtc SubscribeReports")
+	}
+
+	// register the feed before subscribing so that the active orders are not lost.
+	c.subMu.Lock()
+	o := newSubOptions(opts)
+	if s := c.updates.lookup(FeedReports, ""); s != nil && s.opts != o {
+		c.subMu.Unlock()
+		return nil, nil, errors.Annotate(ErrSubscriptionConflict, "Hitbtc SubscribeReports")
+	}
+	registered, err := c.registeredFeed(FeedReports, "", o)
+	if err != nil {
+		c.subMu.Unlock()
+		return nil, nil, errors.Annotate(err, "Hitbtc SubscribeReports")
+	}
+	s := c.addFeed(FeedReports, "", registered, opts)
+	echo, err := c.accountSubscriptionOp(ctx, "subscribeReports")
+	if err != nil {
+		c.updates.unsubscribe(FeedReports, "")
+	}
+	c.subMu.Unlock()
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "Hitbtc SubscribeReports")
+	}
+	s.setConfirmation(echo)
+
+	select {
+	case active := <-s.activeOrders:
+		return active, s.reports, nil
+	case <-ctx.Done():
+		return nil, nil, errors.Annotate(ctx.Err(), "Hitbtc SubscribeReports")
+	}
+}
+
+// WSBalance is the balance of a currency of the trading account on websocket
+type WSBalance struct {
+	Currency  string `json:"currency"`
+	Available string `json:"available"`
+	Reserved  string `json:"reserved"`
+}
+
+// balanceMaxAge is how long a fetched trading balance is reused by GetCurrencyBalance.
+const balanceMaxAge = time.Second
+
+// balanceCache holds the last trading balance fetched.
+type balanceCache struct {
+	mu        sync.Mutex
+	balances  []WSBalance
+	fetchedAt time.Time
+}
+
+// GetTradingBalance obtains the balances of all the currencies of the trading
+// account. The session must be authenticated.
+//
+// The errors reported by hitbtc are returned as *APIError.
+func (c *WSClient) GetTradingBalance(ctx context.Context) ([]WSBalance, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+
+	balances, err := c.tradingBalance(ctx)
+	if err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetTradingBalance")
+	}
+	return balances, nil
+}
+
+// GetCurrencyBalance obtains the balance of the currency in the trading
+// account, an error satisfying errors.IsNotFound if the account has none. The
+// session must be authenticated.
+//
+// The full balance is fetched, so that a balance fetched less than a second
+// before, by GetCurrencyBalance or GetTradingBalance, is reused.
+func (c *WSClient) GetCurrencyBalance(currency string) (*WSBalance, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	if err := validateID("currency", currency); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc GetCurrencyBalance")
+	}
+
+	c.balances.mu.Lock()
+	balances := c.balances.balances
+	if time.Since(c.balances.fetchedAt) >= balanceMaxAge {
+		balances = nil
+	}
+	c.balances.mu.Unlock()
+
+	if balances == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.options.defaultTimeout)
+		defer cancel()
+
+		var err error
+		if balances, err = c.tradingBalance(ctx); err != nil {
+			return nil, errors.Annotate(err, "Hitbtc GetCurrencyBalance")
+		}
+	}
+	for i := range balances {
+		if balances[i].Currency == currency {
+			balance := balances[i]
+			return &balance, nil
+		}
+	}
+	return nil, errors.NotFoundf("Hitbtc GetCurrencyBalance %s", currency)
+}
+
+// tradingBalance fetches the trading balance and keeps it for GetCurrencyBalance.
+func (c *WSClient) tradingBalance(ctx context.Context) ([]WSBalance, error) {
+	var balances []WSBalance
+	if err := c.privateCall(ctx, "getTradingBalance", struct{}{}, &balances); err != nil {
+		return nil, wsAPIError(err)
+	}
+	c.balances.mu.Lock()
+	c.balances.balances = append([]WSBalance{}, balances...) // not shared with the caller
+	c.balances.fetchedAt = time.Now()
+	c.balances.mu.Unlock()
+	return balances, nil
+}
+
+// SubscribeBalance subscribes to the balance changes of the trading account,
+// each notification carrying the new balances of the changed currencies. The
+// session must be authenticated.
+func (c *WSClient) SubscribeBalance(ctx context.Context) (<-chan []WSBalance, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+
+	if err := c.checkKind(FeedBalance); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc SubscribeBalance")
+	}
+
+	c.subMu.Lock()
+	registered, err := c.registeredFeed(FeedBalance, "", subOptions{})
+	if err != nil {
+		c.subMu.Unlock()
+		return nil, errors.Annotate(err, "Hitbtc SubscribeBalance")
+	}
+	s := c.addFeed(FeedBalance, "", registered, nil)
+	echo, err := c.accountSubscriptionOp(ctx, "subscribeBalance")
+	if err != nil {
+		c.updates.unsubscribe(FeedBalance, "")
+	}
+	c.subMu.Unlock()
+	if err != nil {
+		return nil, errors.Annotate(err, "Hitbtc SubscribeBalance")
+	}
+	s.setConfirmation(echo)
+	return s.balances, nil
+}
+
+// accountSubscriptionOp performs the server side subscription of an account feed.
+func (c *WSClient) accountSubscriptionOp(ctx context.Context, method string) (json.RawMessage, error) {
+	var response wsSubscriptionResponse
+	err := c.privateCall(ctx, method, struct{}{}, &response)
+	if err != nil {
+		return nil, err
+	}
+	if !response.success {
+		return nil, errors.New("Subscribe not successful")
+	}
+	return response.echo, nil
+}
+
+// Replaced reports whether the report is the result of an in-place replacement
+// of an order, as opposed to a new order placed after a cancellation.
+func (r WSReport) Replaced() bool {
+	return r.ReportType == ReportTypeReplaced
+}
+
+// KeepsQueuePosition reports whether the replacement of the previous state of
+// the order kept its priority in the order book queue: hitbtc keeps it when the
+// order is replaced in place with the same price and a quantity that is not
+// increased, any other change resets it.
+func (r WSReport) KeepsQueuePosition(previous WSReport) bool {
+	if !r.Replaced() {
+		return false
+	}
+	price, err := decimal.NewFromString(r.Price)
+	if err != nil {
+		return false
+	}
+	previousPrice, err := decimal.NewFromString(previous.Price)
+	if err != nil {
+		return false
+	}
+	quantity, err := decimal.NewFromString(r.Quantity)
+	if err != nil {
+		return false
+	}
+	previousQuantity, err := decimal.NewFromString(previous.Quantity)
+	if err != nil {
+		return false
+	}
+	return price.Equal(previousPrice) && quantity.LessThanOrEqual(previousQuantity)
+}
+
+// WSReplaceOrderRequest is a request to replace an order on websocket
+type WSReplaceOrderRequest struct {
+	ClientOrderID   string `json:"clientOrderId"`   // of the order to replace
+	RequestClientID string `json:"requestClientId"` // new client order id, generated when empty
+	Quantity        string `json:"quantity"`
+	Price           string `json:"price"`
+	Strict          bool   `json:"strict,omitempty"` // fail if the order state changed during the request
+
+	// TimeInForce and ExpireTime of the order, ExpireTime is required for
+	// GTD orders which would otherwise be replaced by GTC ones. They are
+	// carried from the last known report of the order when left out.
+	TimeInForce string `json:"timeInForce,omitempty"`
+	ExpireTime  string `json:"expireTime,omitempty"` // RFC 3339
+}
+
+// validate checks the time in force and the expire time of the request.
+func (r WSReplaceOrderRequest) validate(now time.Time) error {
+	if r.TimeInForce == "" {
+		return errors.NotValidf("replace of order %s without time in force", r.ClientOrderID)
+	}
+	if r.TimeInForce != "GTD" {
+		if r.ExpireTime != "" {
+			return errors.NotValidf("expire time of %s order", r.TimeInForce)
+		}
+		return nil
+	}
+	if r.ExpireTime == "" {
+		return errors.NotValidf("GTD order without expire time")
+	}
+	expire, err := time.Parse(time.RFC3339, r.ExpireTime)
+	if err != nil {
+		return errors.NewNotValid(err, "expire time")
+	}
+	if !expire.After(now) {
+		return errors.NotValidf("past expire time %s", r.ExpireTime)
+	}
+	return nil
+}
+
+// ReplaceOrder replaces the quantity and the price of an active order.
+//
+// The report of an in-place replacement has the ReportTypeReplaced type and
+// carries the replaced client order id in OriginalRequestClientOrderID, see
+// WSReport.Replaced and WSReport.KeepsQueuePosition. The session must be
+// authenticated.
+//
+// The time in force and the expire time of a GTD order must be carried by the
+// request. When the time in force is left out, both are taken from the last
+// known report of the order, see SubscribeReports, and the replace of an
+// unknown order is rejected: hitbtc would replace a GTD order by a GTC one.
+//
+// The errors reported by hitbtc are returned as *APIError, see
+// APIError.IsDeadlineExceeded.
+func (c *WSClient) ReplaceOrder(ctx context.Context, request WSReplaceOrderRequest) (*WSReport, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	if request.TimeInForce == "" {
+		if known, ok := c.updates.orders.get(request.ClientOrderID); ok {
+			request.TimeInForce = known.TimeInForce
+			if request.ExpireTime == "" && !known.ExpireTime.IsZero() {
+				request.ExpireTime = known.ExpireTime.UTC().Format(time.RFC3339Nano)
+			}
+		}
+	}
+	if err := request.validate(time.Now()); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc ReplaceOrder")
+	}
+	if request.RequestClientID == "" {
+		request.RequestClientID = NewClientOrderID()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.options.timeout(tradeMethod))
+	defer cancel()
+
+	var report WSReport
+	err := c.privateCall(ctx, "cancelReplaceOrder", request, &report)
+	if err != nil {
+		return nil, errors.Annotate(wsAPIError(err), "Hitbtc ReplaceOrder")
+	}
+	return &report, nil
+}
+
+// WSNewOrderRequest is a request to place an order on websocket
+type WSNewOrderRequest struct {
+	ClientOrderID  string `json:"clientOrderId"` // generated when empty
+	Symbol         string `json:"symbol"`
+	Side           string `json:"side"`                  // buy or sell
+	Type           string `json:"type,omitempty"`        // limit (default), market, stopLimit or stopMarket
+	TimeInForce    string `json:"timeInForce,omitempty"` // GTC (default), IOC, FOK, Day or GTD
+	Quantity       string `json:"quantity"`
+	Price          string `json:"price,omitempty"`
+	StopPrice      string `json:"stopPrice,omitempty"`
+	ExpireTime     string `json:"expireTime,omitempty"` // RFC 3339, required for GTD orders
+	StrictValidate bool   `json:"strictValidate,omitempty"`
+	PostOnly       bool   `json:"postOnly,omitempty"`
+
+	// RoundToMarket snaps the price and the stop price to the tick size of the
+	// market, and the quantity to its quantity increment, before sending the
+	// order, see WSGetSymbolResponse.RoundPrice and RoundQuantity. The market
+	// metadata is fetched once per client.
+	RoundToMarket bool `json:"-"`
+}
+
+// sentOrderTTL is how long the client order ids sent by PlaceOrder are
+// remembered to detect the retries.
+const sentOrderTTL = time.Hour
+
+// sentOrderIDs remembers the client order ids recently sent by PlaceOrder.
+type sentOrderIDs struct {
+	mu  sync.Mutex
+	ids map[string]time.Time // by sending time
+}
+
+// record records the id as sent at now, and reports whether it was already
+// sent. The ids older than sentOrderTTL are forgotten.
+func (s *sentOrderIDs) record(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids == nil {
+		s.ids = make(map[string]time.Time)
+	}
+	for sent, at := range s.ids {
+		if now.Sub(at) > sentOrderTTL {
+			delete(s.ids, sent)
+		}
+	}
+	_, sent := s.ids[id]
+	s.ids[id] = now
+	return sent
+}
+
+// forget forgets the id, e.g. of an order rejected by hitbtc.
+func (s *sentOrderIDs) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ids, id)
+}
+
+// PlaceOrder places a new order. The session must be authenticated.
+//
+// The order is identified by its client order id, generated when empty, which
+// makes the retries safe: when the id was already sent by the client, e.g.
+// its response was lost on a connection failure, the active orders are looked
+// up first and the existing order is returned instead of placing it again.
+// An order completed meanwhile is returned with its terminal report when the
+// reports are subscribed, see SubscribeReports. Otherwise the order is not
+// sent again and ErrOrderOutcomeUnknown is returned. An order rejected by
+// hitbtc may be placed again with the same id.
+//
+// The errors reported by hitbtc are returned as *APIError, and ErrCircuitOpen
+// while the placements fail fast, see WithOrderCircuitBreaker.
+func (c *WSClient) PlaceOrder(ctx context.Context, request WSNewOrderRequest) (*WSReport, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	if request.ClientOrderID == "" {
+		request.ClientOrderID = NewClientOrderID()
+	}
+	request.Symbol = c.ResolveSymbol(request.Symbol)
+
+	ctx, cancel := context.WithTimeout(ctx, c.options.timeout(tradeMethod))
+	defer cancel()
+
+	if request.RoundToMarket {
+		if err := c.roundToMarket(ctx, &request); err != nil {
+			return nil, errors.Annotate(err, "Hitbtc PlaceOrder")
+		}
+	}
+	if err := c.circuit.allow(c.options, time.Now()); err != nil {
+		return nil, errors.Annotate(err, "Hitbtc PlaceOrder")
+	}
+	report, err := c.placeOrder(ctx, request)
+	c.circuit.record(c.options, err, time.Now())
+	if err != nil {
+		return nil, errors.Annotate(err, "Hitbtc PlaceOrder")
+	}
+	return report, nil
+}
+
+// placeOrder places the order unless it was placed already by a previous
+// attempt.
+func (c *WSClient) placeOrder(ctx context.Context, request WSNewOrderRequest) (*WSReport, error) {
+	if c.sentOrders.record(request.ClientOrderID, time.Now()) {
+		report, err := c.activeOrder(ctx, request.ClientOrderID)
+		if err != nil {
+			return nil, wsAPIError(err)
+		}
+		if report != nil {
+			return report, nil
+		}
+		if completed, ok := c.updates.fills.terminal(request.ClientOrderID); ok {
+			return &completed, nil
+		}
+		return nil, errors.Annotatef(ErrOrderOutcomeUnknown, "clientOrderId %s", request.ClientOrderID)
+	}
+
+	var report WSReport
+	err := c.privateCall(ctx, "newOrder", request, &report)
+	if err != nil {
+		err = wsAPIError(err)
+		if IsAPIError(err) {
+			c.sentOrders.forget(request.ClientOrderID)
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+// roundToMarket snaps the prices and the quantity of the order to its market.
+func (c *WSClient) roundToMarket(ctx context.Context, request *WSNewOrderRequest) error {
+	side := parseTradeSide(request.Side)
+	if side == TradeSideUnknown {
+		return errors.NotValidf("side %q", request.Side)
+	}
+	market, err := c.market(ctx, request.Symbol)
+	if err != nil {
+		return err
+	}
+
+	quantity, err := parseDecimal("quantity", request.Quantity)
+	if err != nil {
+		return err
+	}
+	if quantity, err = market.RoundQuantity(quantity); err != nil {
+		return err
+	}
+	request.Quantity = quantity.String()
+
+	for _, price := range []*string{&request.Price, &request.StopPrice} {
+		if *price == "" {
+			continue
+		}
+		d, err := parseDecimal("price", *price)
+		if err != nil {
+			return err
+		}
+		if d, err = market.RoundPrice(d, side); err != nil {
+			return err
+		}
+		*price = d.String()
+	}
+	return nil
+}
+
+// marketCache holds the metadata of the markets fetched to round the orders,
+// which is not expected to change during a session.
+type marketCache struct {
+	mu      sync.Mutex
+	markets map[string]WSGetSymbolResponse
+}
+
+// market returns the metadata of the market, fetched on first use.
+func (c *WSClient) market(ctx context.Context, symbol string) (WSGetSymbolResponse, error) {
+	c.markets.mu.Lock()
+	market, ok := c.markets.markets[symbol]
+	c.markets.mu.Unlock()
+	if ok {
+		return market, nil
+	}
+
+	if err := validateID("symbol", symbol); err != nil {
+		return market, err
+	}
+	if err := c.callContext(ctx, "getSymbol", WSGetSymbolRequest{Symbol: symbol}, &market); err != nil {
+		return market, errors.Annotate(err, "getSymbol")
+	}
+
+	c.markets.mu.Lock()
+	defer c.markets.mu.Unlock()
+	if c.markets.markets == nil {
+		c.markets.markets = make(map[string]WSGetSymbolResponse)
+	}
+	c.markets.markets[symbol] = market
+	return market, nil
+}
+
+// activeOrder returns the active order of the client order id, nil if there is none.
+func (c *WSClient) activeOrder(ctx context.Context, clientOrderID string) (*WSReport, error) {
+	var orders []WSReport
+	err := c.privateCall(ctx, "getOrders", struct{}{}, &orders)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		if orders[i].ClientOrderID == clientOrderID {
+			return &orders[i], nil
+		}
+	}
+	return nil, nil
+}